@@ -0,0 +1,724 @@
+// Package build renders webjot sites: template expansion, layouts, format conversion & external commands.
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	tt "text/template"
+	"time"
+
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+/*
+Renders a site: templates in the source tree are expanded (inside their
+layouts) into Sink, and all other non-hidden files are copied there.
+
+Use New() to construct a Builder from Options.
+*/
+type Builder struct {
+	SrcFS       fs.FS  // site sources, rooted at the site root
+	SrcRoot     string // OS path of SrcFS ("" = not on disk)
+	PubDir      string
+	ConfDir     string // OS path of CFGDIR ("" = not on disk)
+	DirMode     os.FileMode
+	FileMode    os.FileMode
+	IsWatchMode bool
+	IsStrict    bool // missing vars & non-conforming header keys are errors
+	IsKeepGoing bool // continue building after file errors (otherwise stop at first)
+	Cmd         CmdPolicy
+	Formats     Formats
+	Config      SiteConfig
+	Sink        Sink
+	Funcs       tt.FuncMap // extra template funcs
+	OnEvent     EventFunc
+
+	srcDir        string
+	rxHdrDelim    *regexp.Regexp
+	funcProviders []FuncProvider
+	stats         *BuildStats
+	assets        assetMap          // fingerprinted outputs, for the current Build()
+	digests       map[string]string // SRI digests of assets, by output path
+	images        map[string]Image  // resized image variants, by cache key
+	deps          depMap            // imported stylesheets
+	pages         map[string]Doc    // rendered documents, by output path
+	outputs       map[string]bool   // paths written to Sink in the current Build()
+	pending       pendingMap        // deferred outputs, by output path
+	imgRefs       map[string]bool   // source images: true = only used by imgResize
+	minifier      *outMinifier
+	mL2D          Layout2Docs
+	mLo           Layouts
+}
+
+type Doc struct {
+	DocProps
+	TmplName   string
+	LayoutName string
+	Tmpl       *tt.Template
+
+	conv *convCtx // site access for built-in conversions
+}
+
+type Layout2Docs map[string][]Doc
+type Layouts map[string]Doc
+
+func (oB *Builder) SetHdrDelim(headerDelim string) (err error) {
+	if len(headerDelim) == 0 {
+		oB.rxHdrDelim = nil
+	} else {
+		hdrPat := `(?:^|\r?\n)` + regexp.QuoteMeta(headerDelim) + `(?:$|\r?\n)`
+		oB.rxHdrDelim, err = regexp.Compile(hdrPat)
+	}
+	return
+}
+
+func (oB *Builder) GetHdrDelim() *regexp.Regexp {
+	return oB.rxHdrDelim
+}
+
+func (oB Builder) getDocAndAutoVars(srcpath string) (DocProps, error) {
+
+	doc, err := LoadDocProps(oB.SrcFS, srcpath, oB.rxHdrDelim)
+	if err != nil {
+		return doc, err
+	}
+
+	doc.DstPath = oB.SrcPath2DstRel(srcpath)
+
+	// auto vars
+	// NOTE: paths are OS paths when sources are on disk
+	doc.Vars["URI_PATH"] = doc.DstPath
+	doc.Vars["CFGDIR"] = CFGDIR
+	doc.Vars["SRC"] = srcpath
+	doc.Vars["SRCDIR"] = "."
+	if len(oB.SrcRoot) > 0 {
+		doc.Vars["CFGDIR"] = oB.ConfDir
+		doc.Vars["SRC"] = oB.osPath(srcpath)
+		doc.Vars["SRCDIR"] = oB.SrcRoot
+	}
+	doc.Vars["SRCMOD"] = doc.Info.ModTime().Format(time.RFC3339)
+	doc.Vars["PUBDIR"] = oB.PubDir
+	// NOTE: always defined, for strict mode (commands only see it in watch mode)
+	doc.Vars["WATCHMODE"] = ""
+	if oB.IsWatchMode {
+		doc.Vars["WATCHMODE"] = "enabled"
+	}
+
+	return doc, oB.checkKeys(srcpath, doc)
+}
+
+/*
+In strict mode, non-conforming header keys are errors, located at the
+first such key in the header of `srcpath`.
+*/
+func (oB Builder) checkKeys(srcpath string, dp DocProps) error {
+	if !oB.IsStrict || (len(dp.NonConformingKeys) == 0) {
+		return nil
+	}
+	sKeys := append([]string(nil), dp.NonConformingKeys...)
+	sort.Strings(sKeys)
+	te := &TemplateError{
+		File: srcpath,
+		Line: 1,
+		Msg:  fmt.Sprintf("non-conforming header keys: %s", strings.Join(sKeys, ", ")),
+	}
+
+	bs, err := fs.ReadFile(oB.SrcFS, srcpath)
+	if err != nil {
+		return te
+	}
+	sLines := strings.Split(string(bs), "\n")
+	for ix := 0; (ix < len(sLines)) && (ix < dp.BodyLine-1); ix++ {
+		ln := strings.TrimRight(sLines[ix], "\r")
+		k := strings.Trim(strings.TrimSpace(strings.SplitN(ln, ":", 2)[0]), `"'`)
+		for _, nc := range dp.NonConformingKeys {
+			if k == nc {
+				te.Line, te.Excerpt = ix+1, excerpt(ln, ix+1, 0)
+				return te
+			}
+		}
+	}
+	return te
+}
+
+/*
+OS path of source path `srcpath`.
+Returns "" when sources aren't on disk.
+*/
+func (oB Builder) osPath(srcpath string) string {
+	if len(oB.SrcRoot) == 0 {
+		return ""
+	}
+	return filepath.Join(oB.SrcRoot, filepath.FromSlash(srcpath))
+}
+
+// True for source paths inside CFGDIR.
+func isConfPath(srcpath string) bool {
+	return (srcpath == CFGDIR) || strings.HasPrefix(srcpath, CFGDIR+"/")
+}
+
+/*
+True for stylesheet partials (`_name.scss`, `_name.gcss`), which are only
+built through the documents that import them.
+*/
+func isStylePartial(srcpath string) bool {
+	switch path.Ext(srcpath) {
+	case ".scss", ".gcss":
+		return strings.HasPrefix(path.Base(srcpath), "_")
+	}
+	return false
+}
+
+/*
+Render each document in mLayout inside its specified layout.
+Errors are reported through OnEvent; unless IsKeepGoing is set, rendering
+stops at the first.
+*/
+func (oB Builder) ApplyLayouts(mLayout Layout2Docs, mLo Layouts) {
+
+	// get total document count
+	nDocs := 0
+	for _, sDocs := range mLayout {
+		nDocs += len(sDocs)
+	}
+
+	// build vars list & templates map for all docs
+	sNavDocs := make([]vars.Vars, 0, nDocs)
+	mDocs := make(DocsMap, nDocs)
+	vinit := vars.GetEnvGlobals()
+	for loName, sDocs := range mLayout {
+		// vars hierarchy (global < layout < document)
+		vbase := vinit
+		if docLo, ok := mLo[loName]; ok {
+			vbase = vars.MergeVars(vinit, docLo.Vars)
+		}
+		for _, doc := range sDocs {
+			doc.Vars = vars.MergeVars(vbase, doc.Vars)
+			if oB.Formats.IsLayoutableExt(filepath.Ext(doc.TmplName)) {
+				sNavDocs = append(sNavDocs, doc.Vars)
+			}
+			mDocs[doc.TmplName] = doc
+		}
+	}
+
+	ptDefault := oB.NewTemplate("", vars.DefaultDelims())
+	ptDefault.Parse(`{{ doTmpl .DOC_KEY . }}`)
+
+	// get layout templates
+	mLoTmpl := make(map[string]*tt.Template, len(mLayout))
+	for docLayout := range mLayout {
+		if len(docLayout) == 0 {
+			mLoTmpl[docLayout] = ptDefault
+			continue
+		}
+		docLo := mLo[docLayout]
+		if docLo.Tmpl == nil {
+			oB.emit(Event{Kind: EVT_ERROR, Src: docLayout, Err: errors.New("layout not found")})
+			if !oB.IsKeepGoing {
+				return
+			}
+			continue
+		}
+		mLoTmpl[docLayout] = docLo.Tmpl
+	}
+
+	// renders doc into Sink; errors are reported through OnEvent
+	fnRender := func(pLayoutTmpl *tt.Template, doc Doc) error {
+
+		// don't render to /.pub docs marked as skip: true
+		if bSkip, _ := doc.Vars["skip"].(bool); bSkip {
+			oB.emit(Event{Kind: EVT_SKIP, Src: doc.TmplName, DocType: DT_DOC})
+			return nil
+		}
+
+		// render document to destination file
+		tStart := time.Now()
+		dst, err := oB.renderDoc(pLayoutTmpl, doc, mDocs, sNavDocs)
+		if err != nil {
+			err = oB.templateErr(err)
+			oB.emit(Event{
+				Kind:    EVT_ERROR,
+				Src:     doc.TmplName,
+				DocType: DT_DOC,
+				Err:     err,
+				Elapsed: time.Since(tStart),
+			})
+			return err
+		}
+		if oB.pages != nil {
+			oB.pages[dst] = doc
+		}
+		oB.emit(Event{
+			Kind:    EVT_RENDER,
+			Src:     doc.TmplName,
+			Dst:     dst,
+			DocType: DT_DOC,
+			Elapsed: time.Since(tStart),
+		})
+		return nil
+	}
+
+	// render assets first, so pages can reference them (see `asset` & `sri`)
+	// NOTE: assets render in path order, or on demand when referenced by
+	// another asset (see flushPending)
+	if oB.pending == nil {
+		oB.pending = make(pendingMap)
+	}
+	var sAssets []string
+	for docLayout, sDocs := range mLayout {
+		pLayoutTmpl, ok := mLoTmpl[docLayout]
+		if !ok {
+			continue
+		}
+		for _, doc := range sDocs {
+			if !oB.isAsset(doc) {
+				continue
+			}
+			pLayoutTmpl, doc := pLayoutTmpl, doc
+			oB.pending[doc.DstPath] = func() error { return fnRender(pLayoutTmpl, doc) }
+			sAssets = append(sAssets, doc.DstPath)
+		}
+	}
+	sort.Strings(sAssets)
+	for _, dst := range sAssets {
+		if err := oB.flushPending(dst); (err != nil) && !oB.IsKeepGoing {
+			return
+		}
+	}
+
+	// bundles concatenate rendered assets
+	if !oB.writeBundles(mDocs, sNavDocs) {
+		return
+	}
+
+	for docLayout, sDocs := range mLayout {
+		pLayoutTmpl, ok := mLoTmpl[docLayout]
+		if !ok {
+			continue
+		}
+		for _, doc := range sDocs {
+			if oB.isAsset(doc) {
+				continue
+			}
+			if err := fnRender(pLayoutTmpl, doc); (err != nil) && !oB.IsKeepGoing {
+				return
+			}
+		}
+	}
+
+	// source images not replaced by variants (see imgResize)
+	if !oB.flushAllPending() {
+		return
+	}
+
+	if err := oB.writeManifest(); err != nil {
+		oB.emit(Event{Kind: EVT_ERROR, Src: oB.Config.Fingerprint.manifestPath(), Err: err})
+	}
+}
+
+/*
+Renders doc inside layout pLayoutTmpl, into Sink.
+Returns the destination path (which differs from doc.DstPath when
+fingerprinted).
+*/
+func (oB Builder) renderDoc(
+	pLayoutTmpl *tt.Template,
+	doc Doc,
+	mDocs DocsMap,
+	sNavDocs []vars.Vars,
+) (string, error) {
+
+	// get merged vars
+	dmerged, ok := mDocs[doc.TmplName]
+	if !ok {
+		return "", errors.New("Doc not found")
+	}
+
+	// clone pre-merged vars
+	execVars := make(vars.Vars, len(dmerged.Vars)+1)
+	for k, v := range dmerged.Vars {
+		execVars[k] = v
+	}
+	execVars["DOC_KEY"] = doc.TmplName
+
+	// NOTE: re-populate Funcs() on each doc to bind updated Vars
+	pLayoutTmpl.Funcs(oB.funcMap(doc.TmplName, mDocs, sNavDocs))
+
+	mime := oB.minifyMime(doc.DstPath, execVars)
+
+	// assets: fingerprint & digest depend on content
+	if oB.isAsset(doc) {
+		var buf bytes.Buffer
+		if err := pLayoutTmpl.Execute(&buf, execVars); err != nil {
+			return "", err
+		}
+		bs, err := oB.minifyBytes(mime, buf.Bytes())
+		if err != nil {
+			return "", err
+		}
+		return oB.writeAsset(doc.DstPath, bs, nil)
+	}
+
+	fDst, err := oB.Sink.Create(doc.DstPath)
+	if err != nil {
+		return "", err
+	}
+	defer fDst.Close()
+	oB.wrote(doc.DstPath)
+
+	iMin := oB.minifyWriter(mime, fDst)
+	if err = pLayoutTmpl.Execute(iMin, execVars); err != nil {
+		return "", err
+	}
+	return doc.DstPath, iMin.Close()
+}
+
+/*
+Determine destination filename from source filename.
+Both are slash-separated, relative to the site & output roots.
+*/
+func (oB Builder) SrcPath2DstRel(srcpath string) string {
+	// extension changes (i.e. md -> html), if any
+	ext := path.Ext(srcpath)
+	return strings.TrimSuffix(srcpath, ext) + oB.Formats.OutExt(ext)
+}
+
+func (oB Builder) compileLayout(srcpath string) (*Doc, error) {
+
+	// get relative path for layout reference in templates
+	pdoc := &Doc{
+		TmplName: strings.TrimPrefix(srcpath, CFGDIR+"/"),
+	}
+
+	// get layout & its header
+	var err error
+	pdoc.DocProps, err = LoadDocProps(oB.SrcFS, srcpath, oB.rxHdrDelim)
+	if err == nil {
+		err = oB.checkKeys(srcpath, pdoc.DocProps)
+	}
+	if err != nil {
+		return pdoc, err
+	}
+
+	// create layout tmpl, get/set layout delims
+	delete(pdoc.Vars, "layout")
+	pdoc.Tmpl = oB.NewTemplate(srcpath, pdoc.Vars.GetDelims())
+	pdoc.Tmpl, err = pdoc.Tmpl.Parse(string(pdoc.DocProps.Source))
+	return pdoc, err
+}
+
+func (oB Builder) compileOrCopyFile(srcpath string, vinit vars.Vars) (*Doc, error) {
+
+	// simple copy & early-exit for unprocessed extensions
+	ext := path.Ext(srcpath)
+	fmtDoc, ok := oB.Formats.Get(ext)
+	if !ok || !fmtDoc.IsProcessed() {
+		return nil, oB.copyFile(oB.SrcPath2DstRel(srcpath), srcpath)
+	}
+
+	// get doc and vars
+	dp, err := oB.getDocAndAutoVars(srcpath)
+	if err != nil {
+		return nil, err
+	}
+
+	// template expansion
+	var tmpl *tt.Template
+	if fmtDoc.IsTemplate {
+		tmpl = oB.NewTemplate(srcpath, dp.Vars.GetDelims())
+		tmpl, err = tmpl.Parse(string(dp.Source))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// layout determination
+	mV := vars.MergeVars(vinit, dp.Vars)
+	docLayout := mV.GetStr("layout")
+	if fmtDoc.IsLayoutable {
+		// disable layout if key is specified, but value is empty
+		// use default layout if unspecified
+		if len(docLayout) == 0 {
+			if _, ok := mV["layout"]; !ok {
+				docLayout = "layout.html"
+			}
+		}
+	} else {
+		// disable layouts for all others
+		docLayout = ""
+	}
+
+	return &Doc{
+		DocProps:   dp,
+		Tmpl:       tmpl,
+		TmplName:   srcpath,
+		LayoutName: docLayout,
+	}, nil
+}
+
+func (oB Builder) copyFile(dstrel, srcpath string) error {
+
+	fSrc, err := oB.SrcFS.Open(srcpath)
+	if err != nil {
+		return err
+	}
+	defer fSrc.Close()
+
+	iSrc, err := fSrc.Stat()
+	if err != nil {
+		return err
+	}
+	mime := oB.minifyMime(dstrel, nil)
+	if oB.isFingerprinted(dstrel) || (len(mime) > 0) {
+		bs, err := io.ReadAll(fSrc)
+		if err == nil {
+			bs, err = oB.minifyBytes(mime, bs)
+		}
+		if err != nil {
+			return err
+		}
+
+		// minified output also depends on site config
+		var info fs.FileInfo = iSrc
+		if len(mime) > 0 {
+			info = memInfo{modTime: oB.confModTime(iSrc.ModTime())}
+		}
+		_, err = oB.writeAsset(dstrel, bs, info)
+		return err
+	}
+	if err = oB.Sink.Copy(dstrel, fSrc, iSrc); err != nil {
+		return err
+	}
+	oB.wrote(dstrel)
+	return nil
+}
+
+/*
+Records output path `rel` as written in the current Build().
+*/
+func (oB Builder) wrote(rel string) {
+	if oB.outputs != nil {
+		oB.outputs[rel] = true
+	}
+}
+
+/*
+Later of `t` and the site config's mod time.
+*/
+func (oB Builder) confModTime(t time.Time) time.Time {
+	fi, err := fs.Stat(oB.SrcFS, CFGDIR+"/"+CFGFILE)
+	if (err == nil) && fi.ModTime().After(t) {
+		return fi.ModTime()
+	}
+	return t
+}
+
+type DocType uint
+
+const (
+	DT_NIL DocType = iota
+	DT_DOC
+	DT_LAYOUT
+)
+
+/*
+Compiles the layout or document at `srcpath` (slash-separated, relative to
+the site root) into mLo or mL2D, or copies it into Sink.  Stylesheet
+partials are skipped.  Progress & errors are reported through OnEvent.
+*/
+func (oB Builder) BuildFile(
+	srcpath string,
+	vinit vars.Vars,
+	mL2D Layout2Docs,
+	mLo Layouts,
+) (pdoc *Doc, dt DocType, err error) {
+
+	if isStylePartial(srcpath) {
+		return
+	}
+
+	bIsConf := isConfPath(srcpath)
+	bIsLayout := bIsConf && oB.Formats.IsLayoutExt(path.Ext(srcpath))
+	tStart := time.Now()
+
+	defer func() {
+		if bIsConf && !bIsLayout {
+			return
+		}
+		if bIsLayout {
+			dt = DT_LAYOUT
+		}
+		elapsed := time.Since(tStart)
+		oB.emit(Event{Kind: EVT_BUILD, Src: srcpath, DocType: dt, Doc: pdoc, Elapsed: elapsed})
+		if err != nil {
+			err = oB.templateErr(err)
+			oB.emit(Event{Kind: EVT_ERROR, Src: srcpath, DocType: dt, Err: err, Elapsed: elapsed})
+		}
+	}()
+
+	// compile layout
+	if bIsConf {
+		if bIsLayout {
+			pdoc, err = oB.compileLayout(srcpath)
+			if err != nil {
+				return
+			}
+			mLo[pdoc.TmplName] = *pdoc
+		}
+		return
+	}
+
+	// images only used through imgResize are replaced by their variants
+	if oB.imgRefs[srcpath] {
+		oB.emit(Event{Kind: EVT_SKIP, Src: srcpath, Msg: "replaced by resized variants"})
+		if rs, ok := oB.Sink.(RemoverSink); ok {
+			err = rs.Remove(oB.SrcPath2DstRel(srcpath))
+		}
+		return
+	}
+
+	// compile templates / copy others into `.pub/`
+	// NOTE: digest is re-computed on demand, when not recorded by copy
+	delete(oB.digests, oB.SrcPath2DstRel(srcpath))
+	pdoc, err = oB.compileOrCopyFile(srcpath, vinit)
+	if err != nil {
+		return
+	}
+	if pdoc == nil {
+		oB.emit(Event{Kind: EVT_COPY, Src: srcpath, Dst: oB.assetPath(oB.SrcPath2DstRel(srcpath))})
+	}
+
+	// append doc to layout map
+	if pdoc != nil {
+		sDocs := mL2D[pdoc.LayoutName]
+		// if exists, overwrite
+		bFound := false
+		for ix := range sDocs {
+			if sDocs[ix].TmplName == pdoc.TmplName {
+				sDocs[ix] = *pdoc
+				bFound = true
+				break
+			}
+		}
+		// if not found, append
+		if !bFound {
+			sDocs = append(sDocs, *pdoc)
+		}
+		mL2D[pdoc.LayoutName] = sDocs
+		dt = DT_DOC
+	}
+
+	return
+}
+
+/*
+Builds all files under the source directory, then renders documents into
+their layouts.  Per-file errors are reported through OnEvent; unless
+IsKeepGoing is set, the build stops at the first.  When any file failed,
+ErrBuildFailed is returned.  See Stats() for totals.
+*/
+func (oB *Builder) Build() error {
+
+	tStart := time.Now()
+	if oB.stats == nil {
+		oB.stats = new(BuildStats)
+	}
+	*oB.stats = BuildStats{}
+	oB.assets = make(assetMap)
+	oB.digests = make(map[string]string)
+	oB.images = make(map[string]Image)
+	oB.deps = make(depMap)
+	oB.pages = make(map[string]Doc)
+	oB.outputs = make(map[string]bool)
+	oB.pending = make(pendingMap)
+	oB.imgRefs = make(map[string]bool)
+	defer func() {
+		oB.stats.Elapsed = time.Since(tStart)
+	}()
+
+	oB.mL2D = make(Layout2Docs)
+	oB.mLo = make(Layouts)
+	vinit := vars.GetEnvGlobals()
+
+	// recurse through source dir
+	wdFunc := func(srcpath string, info fs.DirEntry, eWalk error) error {
+
+		if eWalk != nil {
+			return EWrap(eWalk, srcpath)
+		}
+
+		fname := info.Name()
+		bHidden := strings.HasPrefix(fname, ".") && (srcpath != ".")
+		if info.IsDir() {
+			// don't recurse hidden dirs except for CFGDIR
+			if bHidden && (srcpath != CFGDIR) {
+				return fs.SkipDir
+			}
+			// don't recurse into cache
+			if srcpath == path.Join(CFGDIR, CACHEDIR) {
+				return fs.SkipDir
+			}
+			// recurse into ordinary dirs
+			return nil
+		}
+
+		// skip hidden files
+		if bHidden {
+			return nil
+		}
+
+		// copy images last, once imgResize users are known (see ApplyLayouts)
+		if oB.isSrcImage(srcpath) {
+			oB.pending[oB.SrcPath2DstRel(srcpath)] = func() error {
+				_, _, err := oB.BuildFile(srcpath, vinit, oB.mL2D, oB.mLo)
+				return err
+			}
+			return nil
+		}
+
+		// build others
+		if _, _, err := oB.BuildFile(srcpath, vinit, oB.mL2D, oB.mLo); err != nil {
+			if !oB.IsKeepGoing {
+				return errStop
+			}
+		}
+		return nil
+	}
+	err := fs.WalkDir(oB.SrcFS, oB.srcDir, wdFunc)
+	if (err != nil) && (err != errStop) {
+		return err
+	}
+
+	// parse layouts, render nested templates
+	if err == nil {
+		oB.ApplyLayouts(oB.mL2D, oB.mLo)
+	}
+
+	if oB.stats.Errors > 0 {
+		return fmt.Errorf("%w: %d error(s)", ErrBuildFailed, oB.stats.Errors)
+	}
+	return nil
+}
+
+// stops WalkDir at first error, when not IsKeepGoing
+var errStop = errors.New("stop")
+
+/*
+Built site, as a filesystem.
+Returns nil when the Sink can't be read back (i.e. archives).
+*/
+func (oB Builder) Output() fs.FS {
+	if fss, ok := oB.Sink.(FSSink); ok {
+		return fss.FS()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Package buildtest provides helpers for golden-file tests of webjot sites.
+package buildtest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/BourgeoisBear/webjot/build"
+)
+
+/*
+Builds the site at opt.SrcDir (or opt.SrcFS) into memory, and returns its
+output.  Per-file build errors fail tb (all are reported).
+*/
+func Build(tb testing.TB, opt build.Options) fs.FS {
+
+	tb.Helper()
+
+	fnEvt := opt.OnEvent
+	opt.Sink = build.NewMemSink()
+	opt.IsKeepGoing = true
+	opt.OnEvent = func(ev build.Event) {
+		if ev.Kind == build.EVT_ERROR {
+			tb.Errorf("[%s] %v", ev.Src, ev.Err)
+		}
+		if fnEvt != nil {
+			fnEvt(ev)
+		}
+	}
+
+	pB, err := build.New(opt)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = pB.Build(); err != nil {
+		tb.Fatal(err)
+	}
+	return pB.Output()
+}
+
+/*
+Builds the site like Build(), but returns its errors & warnings (in order)
+instead of failing tb.  Other events still reach opt.OnEvent.
+*/
+func BuildEvents(tb testing.TB, opt build.Options) (fs.FS, []build.Event) {
+	tb.Helper()
+	return CheckEvents(tb, opt, nil)
+}
+
+/*
+Builds the site like BuildEvents(), then runs post-build check fnCheck
+(i.e. `(*build.Builder).CheckLinks`), when given.  Returns errors &
+warnings from both.
+*/
+func CheckEvents(
+	tb testing.TB,
+	opt build.Options,
+	fnCheck func(*build.Builder) error,
+) (fs.FS, []build.Event) {
+
+	tb.Helper()
+
+	var ret []build.Event
+	fnEvt := opt.OnEvent
+	opt.Sink = build.NewMemSink()
+	opt.IsKeepGoing = true
+	opt.OnEvent = func(ev build.Event) {
+		if (ev.Kind == build.EVT_ERROR) || (ev.Kind == build.EVT_WARN) {
+			ret = append(ret, ev)
+		}
+		if fnEvt != nil {
+			fnEvt(ev)
+		}
+	}
+
+	pB, err := build.New(opt)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = pB.Build(); (err != nil) && !errors.Is(err, build.ErrBuildFailed) {
+		tb.Fatal(err)
+	}
+	if fnCheck != nil {
+		// failures are reported as events; others fail tb
+		nEvt := len(ret)
+		if err = fnCheck(pB); (err != nil) && (len(ret) == nEvt) {
+			tb.Fatal(err)
+		}
+	}
+	return pB.Output(), ret
+}
+
+/*
+Builds the site at siteDir, and diffs its output against goldenDir.
+When bUpdate is set, goldenDir is re-written from the output instead.
+*/
+func CheckGolden(tb testing.TB, siteDir, goldenDir string, bUpdate bool) {
+
+	tb.Helper()
+
+	got := Build(tb, build.Options{SrcDir: siteDir})
+	if bUpdate {
+		if err := build.WriteGolden(got, goldenDir, 0755, 0644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+
+	diffs, err := build.DiffFS(got, os.DirFS(goldenDir))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, d := range diffs {
+		tb.Error(d)
+	}
+}
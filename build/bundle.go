@@ -0,0 +1,249 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+/*
+A bundle, from the `bundles` section of site config: input files
+(source paths, relative to the site root) concatenated in order, after
+template expansion & conversion, into one output file.
+*/
+type BundleConf struct {
+	Files     []string `yaml:"files"`
+	SourceMap bool     `yaml:"sourcemap"` // also write `<bundle>.map` (bundle isn't minified)
+}
+
+/*
+Validates bundle definitions, and normalizes output & input paths.
+*/
+func cleanBundles(mB map[string]BundleConf) (map[string]BundleConf, error) {
+	ret := make(map[string]BundleConf, len(mB))
+	for name, bc := range mB {
+		switch strings.ToLower(path.Ext(name)) {
+		case ".css", ".js", ".mjs":
+		default:
+			return nil, fmt.Errorf("bundles: `%s`: output must be .css or .js", name)
+		}
+		if len(bc.Files) == 0 {
+			return nil, fmt.Errorf("bundles: `%s`: no files", name)
+		}
+		sFiles := make([]string, len(bc.Files))
+		for i, f := range bc.Files {
+			sFiles[i] = path.Clean(strings.TrimPrefix(f, "/"))
+		}
+		bc.Files = sFiles
+		ret[path.Clean(strings.TrimPrefix(name, "/"))] = bc
+	}
+	return ret, nil
+}
+
+/*
+URL of bundle `name` (fingerprinted when enabled), for the `bundle`
+template func.
+*/
+func (oB Builder) bundleURL(name string) (string, error) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if _, ok := oB.Config.Bundles[name]; !ok {
+		return "", fmt.Errorf("bundle `%s` not defined in %s", name, CFGFILE)
+	}
+	return "/" + oB.assetPath(name), nil
+}
+
+/*
+Contents of bundle input `rel`: documents are rendered (like `doTmpl`),
+static files are read as-is.
+*/
+func (oB Builder) bundleInput(rel string, mDocs DocsMap, sNavDocs []vars.Vars) ([]byte, error) {
+
+	doc, ok := mDocs[rel]
+	if !ok {
+		if fmtDoc, ok := oB.Formats.Get(path.Ext(rel)); ok && fmtDoc.IsProcessed() {
+			return nil, fmt.Errorf("bundle input `%s` not found (or not built)", rel)
+		}
+		return fs.ReadFile(oB.SrcFS, rel)
+	}
+
+	execVars := make(vars.Vars, len(doc.Vars)+1)
+	for k, v := range doc.Vars {
+		execVars[k] = v
+	}
+	execVars["DOC_KEY"] = rel
+
+	fnWarn := func(err error) {
+		oB.emit(Event{Kind: EVT_WARN, Src: rel, Err: err})
+	}
+	if doc.Tmpl != nil {
+		doc.Tmpl.Funcs(oB.funcMap(rel, mDocs, sNavDocs))
+	}
+	var buf bytes.Buffer
+	err := oB.postProcess(&buf, doc, execVars, fnWarn)
+	return buf.Bytes(), oB.templateErr(err)
+}
+
+const b64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// Appends `v` in source map base64 VLQ.
+func vlqAppend(sb *strings.Builder, v int) {
+	u := v << 1
+	if v < 0 {
+		u = (-v << 1) | 1
+	}
+	for {
+		d := u & 31
+		if u >>= 5; u > 0 {
+			d |= 32
+		}
+		sb.WriteByte(b64Digits[d])
+		if u == 0 {
+			return
+		}
+	}
+}
+
+/*
+Version 3 source map, mapping each line of a bundle to its input line.
+*/
+type sourceMap struct {
+	Version        int      `json:"version"`
+	File           string   `json:"file"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+}
+
+/*
+Concatenates, minifies (unless mapped), & writes bundle `name` and its
+source map (if any) into Sink.  Returns the bundle's output path.
+*/
+func (oB Builder) writeBundle(
+	name string, bc BundleConf, mDocs DocsMap, sNavDocs []vars.Vars,
+) (string, error) {
+
+	bJS := !strings.EqualFold(path.Ext(name), ".css")
+	sm := sourceMap{Version: 3, Names: []string{}}
+	var buf bytes.Buffer
+	var sbMap strings.Builder
+	prevSrc, prevLine, nOutLines := 0, 0, 0
+
+	for ixSrc, rel := range bc.Files {
+		bs, err := oB.bundleInput(rel, mDocs, sNavDocs)
+		if err != nil {
+			return "", err
+		}
+		if (len(bs) > 0) && (bs[len(bs)-1] != '\n') {
+			bs = append(bs, '\n')
+		}
+		buf.Write(bs)
+
+		// NOTE: converted inputs are named after their outputs (`b.gcss` ->
+		//       `b.css`), since that's the content mapped & embedded
+		src := rel
+		if doc, ok := mDocs[rel]; ok {
+			src = doc.DstPath
+		}
+		sm.Sources = append(sm.Sources, "/"+src)
+		sm.SourcesContent = append(sm.SourcesContent, string(bs))
+		for ln, n := 0, bytes.Count(bs, []byte("\n")); ln < n; ln++ {
+			if nOutLines > 0 {
+				sbMap.WriteByte(';')
+			}
+			nOutLines++
+			vlqAppend(&sbMap, 0)
+			vlqAppend(&sbMap, ixSrc-prevSrc)
+			vlqAppend(&sbMap, ln-prevLine)
+			vlqAppend(&sbMap, 0)
+			prevSrc, prevLine = ixSrc, ln
+		}
+
+		// unmapped statement terminator, so files can't run together
+		if bJS {
+			buf.WriteString(";\n")
+			sbMap.WriteByte(';')
+			nOutLines++
+		}
+	}
+
+	if !bc.SourceMap {
+		bs, err := oB.minifyBytes(oB.minifyMime(name, nil), buf.Bytes())
+		if err != nil {
+			return "", err
+		}
+		return oB.writeAsset(name, bs, nil)
+	}
+
+	// NOTE: minification would invalidate the map
+	mapName := path.Base(name) + ".map"
+	if bJS {
+		fmt.Fprintf(&buf, "//# sourceMappingURL=%s\n", mapName)
+	} else {
+		fmt.Fprintf(&buf, "/*# sourceMappingURL=%s */\n", mapName)
+	}
+	outPath, err := oB.writeAsset(name, buf.Bytes(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	sm.File = path.Base(outPath)
+	sm.Mappings = sbMap.String()
+	bs, err := json.Marshal(sm)
+	if err != nil {
+		return "", err
+	}
+	fDst, err := oB.Sink.Create(name + ".map")
+	if err != nil {
+		return "", err
+	}
+	oB.wrote(name + ".map")
+	_, err = fDst.Write(bs)
+	if e2 := fDst.Close(); err == nil {
+		err = e2
+	}
+	return outPath, err
+}
+
+/*
+Writes all bundles, in name order.  Returns false when the build should stop.
+*/
+func (oB Builder) writeBundles(mDocs DocsMap, sNavDocs []vars.Vars) bool {
+
+	sNames := make([]string, 0, len(oB.Config.Bundles))
+	for name := range oB.Config.Bundles {
+		sNames = append(sNames, name)
+	}
+	sort.Strings(sNames)
+
+	for _, name := range sNames {
+		tStart := time.Now()
+		dst, err := oB.writeBundle(name, oB.Config.Bundles[name], mDocs, sNavDocs)
+		if err != nil {
+			oB.emit(Event{
+				Kind:    EVT_ERROR,
+				Src:     name,
+				Err:     err,
+				Elapsed: time.Since(tStart),
+			})
+			if !oB.IsKeepGoing {
+				return false
+			}
+			continue
+		}
+		oB.emit(Event{
+			Kind:    EVT_RENDER,
+			Src:     name,
+			Dst:     dst,
+			Elapsed: time.Since(tStart),
+		})
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package build_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+func TestCheckLinks(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"index.md": {Data: []byte("## The Team\n\n" +
+			"[team](#the-team)\n" +
+			"[docs](/docs/)\n" +
+			"[nobody](/about.html#nobody)\n" +
+			"[gone](gone.html)\n",
+		)},
+		"about.html":      {Data: []byte(`<p id="team">`)},
+		"docs/index.html": {Data: []byte(`<p>`)},
+	}
+
+	_, sEvt := buildtest.CheckEvents(t, build.Options{SrcFS: fsys}, (*build.Builder).CheckLinks)
+
+	sWant := []string{
+		"index.md:5: broken link `/about.html#nobody`: no element w/ id `nobody` in about.html",
+		"index.md:6: broken link `gone.html`: target not found",
+	}
+	if len(sEvt) != len(sWant) {
+		t.Fatalf("got %d events, want %d: %v", len(sEvt), len(sWant), sEvt)
+	}
+	for ix, ev := range sEvt {
+		if (ev.Kind != build.EVT_ERROR) || (ev.Src != "index.md") || (ev.DocType != build.DT_DOC) {
+			t.Errorf("event %d: got %+v", ix, ev)
+		}
+		if ev.Err.Error() != sWant[ix] {
+			t.Errorf("event %d: got %q, want %q", ix, ev.Err, sWant[ix])
+		}
+	}
+}
+
+/*
+Targets left in the output by an earlier build don't satisfy links.
+*/
+func TestCheckLinksStale(t *testing.T) {
+
+	root := t.TempDir()
+	fnWrite := func(rel, data string) {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fnCheck := func() error {
+		pB, err := build.New(build.Options{SrcDir: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pB.Build(); err != nil {
+			t.Fatal(err)
+		}
+		return pB.CheckLinks()
+	}
+
+	fnWrite(".webjot/layout.html", `{{ doTmpl .DOC_KEY . }}`)
+	fnWrite("index.html", `<a href="old.html">old</a>`)
+	fnWrite("old.html", "x")
+	if err := fnCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(root, "old.html")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, build.PUBDIR, "old.html")); err != nil {
+		t.Fatalf("expected stale output: %v", err)
+	}
+	if err := fnCheck(); err == nil {
+		t.Error("link to stale output passed")
+	}
+}
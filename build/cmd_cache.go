@@ -0,0 +1,164 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+const CACHEDIR = "cache"
+
+/*
+Content-addressed store for external command output, kept under
+`<CFGDIR>/cache/cmd/`.  Only successful runs are stored.
+*/
+type CmdCache struct {
+	Dir        string // "" = caching unavailable
+	IsDisabled bool   // skip cache reads & writes (-no-cache)
+}
+
+type cmdCacheEntry struct {
+	Cmd    []string `json:"cmd"`
+	Stdout []byte   `json:"stdout"`
+	Stderr []byte   `json:"stderr"`
+}
+
+func (cc CmdCache) IsEnabled() bool {
+	return !cc.IsDisabled && (len(cc.Dir) > 0)
+}
+
+/*
+Derives a cache key from the command line, working directory, STDIN,
+environment vars, and the contents of declared input files.
+
+When co.CacheEnv is empty, every user-defined var in mV is part of the key,
+but not built-ins (upper-case keys, i.e. SRC & URI_PATH), so output is
+shared between documents & across watch mode.  Otherwise, only the named
+vars are.  Input paths are relative to co.Dir, and may be
+globs.
+*/
+func (cc CmdCache) Key(co CmdOpts, mV vars.Vars, cmd string, args ...string) (string, error) {
+
+	h := sha256.New()
+	fnField := func(s string) {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+
+	fnField(cmd)
+	for _, a := range args {
+		fnField(a)
+	}
+	fnField(co.Dir)
+	fnField(co.Stdin)
+
+	// env vars
+	keys := co.CacheEnv
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(mV))
+		for k := range mV {
+			if !HasUcase(k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fnField(k + "=" + mV.GetStr(k))
+	}
+
+	// input files
+	for _, pat := range co.CacheInputs {
+		if !filepath.IsAbs(pat) {
+			pat = filepath.Join(co.Dir, pat)
+		}
+		sMatch, err := filepath.Glob(pat)
+		if err != nil {
+			return "", err
+		}
+		if len(sMatch) == 0 {
+			return "", EWrap(fs.ErrNotExist, pat)
+		}
+		for _, fname := range sMatch {
+			if err = hashFile(h, fname); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, fname string) error {
+	pf, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	io.WriteString(w, fname)
+	_, err = io.Copy(w, pf)
+	return err
+}
+
+func (cc CmdCache) entryPath(key string) string {
+	return filepath.Join(cc.Dir, "cmd", key[:2], key+".json")
+}
+
+func (cc CmdCache) Get(key string) (sout, serr []byte, ok bool) {
+	bs, err := os.ReadFile(cc.entryPath(key))
+	if err != nil {
+		return nil, nil, false
+	}
+	var ent cmdCacheEntry
+	if err = json.Unmarshal(bs, &ent); err != nil {
+		return nil, nil, false
+	}
+	return ent.Stdout, ent.Stderr, true
+}
+
+func (cc CmdCache) Put(key string, cmdline []string, sout, serr []byte) error {
+
+	bs, err := json.Marshal(cmdCacheEntry{Cmd: cmdline, Stdout: sout, Stderr: serr})
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(cc.entryPath(key), bs)
+}
+
+/*
+Runs a command through the cache when co.IsCached is set.
+Cache write failures are reported through fnWarn.
+*/
+func runCmdCached(
+	cc CmdCache, co CmdOpts, mV vars.Vars, fnWarn func(error), cmd string, args ...string,
+) (sout, serr []byte, err error) {
+
+	if !co.IsCached || !cc.IsEnabled() {
+		return runCmd(co, mV, cmd, args...)
+	}
+
+	key, err := cc.Key(co, mV, cmd, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sout, serr, ok := cc.Get(key); ok {
+		return sout, serr, nil
+	}
+
+	sout, serr, err = runCmd(co, mV, cmd, args...)
+	if err == nil {
+		if e2 := cc.Put(key, append([]string{cmd}, args...), sout, serr); e2 != nil {
+			fnWarn(fmt.Errorf("cmd cache: %w", e2))
+		}
+	}
+	return
+}
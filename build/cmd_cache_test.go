@@ -0,0 +1,43 @@
+package build_test
+
+import (
+	"testing"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+func TestCmdCacheKey(t *testing.T) {
+
+	cc := build.CmdCache{Dir: t.TempDir()}
+	base := vars.Vars{"title": "Log", "SRC": "a.md", "URI_PATH": "a.html", "WATCHMODE": false}
+
+	tests := []struct {
+		name  string
+		env   []string
+		mV    vars.Vars
+		bSame bool
+	}{
+		{"built-ins ignored", nil, vars.Vars{"title": "Log", "SRC": "b.md", "URI_PATH": "b.html", "WATCHMODE": true}, true},
+		{"user var keyed", nil, vars.Vars{"title": "Other", "SRC": "a.md", "URI_PATH": "a.html", "WATCHMODE": false}, false},
+		{"named built-in keyed", []string{"SRC"}, vars.Vars{"title": "Log", "SRC": "b.md"}, false},
+		{"unnamed user var ignored", []string{"SRC"}, vars.Vars{"title": "Other", "SRC": "a.md"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			co := build.CmdOpts{CacheEnv: tc.env}
+			k1, err := cc.Key(co, base, "git", "log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			k2, err := cc.Key(co, tc.mV, "git", "log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (k1 == k2) != tc.bSame {
+				t.Errorf("same key = %v, want %v", k1 == k2, tc.bSame)
+			}
+		})
+	}
+}
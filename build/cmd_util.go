@@ -0,0 +1,435 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BourgeoisBear/webjot/vars"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CMDDIR_DOC  = "doc"  // run commands from the document's directory
+	CMDDIR_ROOT = "root" // run commands from the site root
+)
+
+/*
+Site-wide defaults for external commands run via `doCmd` & friends.
+*/
+type CmdPolicy struct {
+	Timeout  time.Duration // 0 = no timeout
+	DirMode  string        // CMDDIR_DOC | CMDDIR_ROOT
+	IsStrict bool          // non-zero exit / timeout fails the document
+	Cache    CmdCache
+}
+
+/*
+Settings for a single command invocation.
+*/
+type CmdOpts struct {
+	Timeout      time.Duration
+	Dir          string
+	IsStrict     bool
+	IsStdoutOnly bool
+	IsCached     bool
+	CacheEnv     []string // vars that key cached output (empty = all user-defined)
+	CacheInputs  []string // files that key cached output
+	Stdin        string
+	Parse        string // CMDPARSE_JSON | CMDPARSE_YAML | "" (text output)
+	chain        string // vars.ENVVAR_CHAIN value
+}
+
+const (
+	CMDPARSE_JSON = "json"
+	CMDPARSE_YAML = "yaml"
+)
+
+/*
+Returns per-call options for document `doc`, initialized from policy defaults
+and the document's `cmd_cache`, `cmd_cache_env` & `cmd_cache_inputs` vars.
+*/
+func (pol CmdPolicy) OptsFor(doc Doc, rootDir string) CmdOpts {
+	ret := CmdOpts{
+		Timeout:     pol.Timeout,
+		IsStrict:    pol.IsStrict,
+		CacheEnv:    doc.Vars.GetStrList("cmd_cache_env"),
+		CacheInputs: doc.Vars.GetStrList("cmd_cache_inputs"),
+	}
+	ret.IsCached, _ = doc.Vars["cmd_cache"].(bool)
+	if pol.DirMode == CMDDIR_ROOT {
+		ret.Dir = rootDir
+	} else {
+		ret.Dir = docDir(doc, rootDir)
+	}
+	return ret
+}
+
+/*
+OS directory containing doc's source.
+When sources aren't on disk (rootDir == ""), commands run from the current
+directory.
+*/
+func docDir(doc Doc, rootDir string) string {
+	if (len(rootDir) == 0) || (len(doc.SrcPath) == 0) {
+		return rootDir
+	}
+	return filepath.Join(rootDir, filepath.FromSlash(path.Dir(doc.SrcPath)))
+}
+
+/*
+Overrides fields of `co` from a template-supplied options map, i.e.
+
+	{{ doCmdWith (toMap "timeout" "5s" "dir" "root" "stdout" true) "git" "log" }}
+
+Recognized keys:
+
+	timeout: duration string ("1m30s"), or seconds (integer or float)
+	dir:     "doc", "root", or a path relative to the document's directory
+	strict:  true = fail the document on error
+	stdout:  true = capture STDOUT only
+	cache:   true = re-use output from previous builds
+	env:     list of var names that key cached output (default = all)
+	inputs:  list of files (or globs) whose contents key cached output
+	stdin:   text to pipe into the command's STDIN
+	parse:   "json" or "yaml" = parse STDOUT into a value
+*/
+func (co *CmdOpts) Apply(opts map[string]interface{}, doc Doc, rootDir string) error {
+
+	for k, v := range opts {
+		switch k {
+		case "timeout":
+			switch tv := v.(type) {
+			case string:
+				d, err := time.ParseDuration(tv)
+				if err != nil {
+					return fmt.Errorf("doCmd option `timeout`: %w", err)
+				}
+				co.Timeout = d
+			case int:
+				co.Timeout = time.Duration(tv) * time.Second
+			case float64:
+				co.Timeout = time.Duration(tv * float64(time.Second))
+			default:
+				return fmt.Errorf("doCmd option `timeout`: unsupported type %T (want duration string, or seconds)", v)
+			}
+		case "dir":
+			s := fmt.Sprint(v)
+			switch s {
+			case CMDDIR_DOC:
+				co.Dir = docDir(doc, rootDir)
+			case CMDDIR_ROOT:
+				co.Dir = rootDir
+			default:
+				if filepath.IsAbs(s) {
+					co.Dir = s
+				} else {
+					co.Dir = filepath.Join(docDir(doc, rootDir), s)
+				}
+			}
+		case "strict":
+			b, ok := v.(bool)
+			if !ok {
+				return errors.New("doCmd option `strict`: expected bool")
+			}
+			co.IsStrict = b
+		case "stdout":
+			b, ok := v.(bool)
+			if !ok {
+				return errors.New("doCmd option `stdout`: expected bool")
+			}
+			co.IsStdoutOnly = b
+		case "cache":
+			b, ok := v.(bool)
+			if !ok {
+				return errors.New("doCmd option `cache`: expected bool")
+			}
+			co.IsCached = b
+		case "env":
+			co.CacheEnv = vars.Vars{k: v}.GetStrList(k)
+		case "inputs":
+			co.CacheInputs = vars.Vars{k: v}.GetStrList(k)
+		case "stdin":
+			co.Stdin = fmt.Sprint(v)
+		case "parse":
+			switch s := fmt.Sprint(v); s {
+			case CMDPARSE_JSON, CMDPARSE_YAML, "":
+				co.Parse = s
+			default:
+				return fmt.Errorf("doCmd option `parse`: unsupported format `%s`", s)
+			}
+		default:
+			return fmt.Errorf("unknown doCmd option `%s`", k)
+		}
+	}
+	return nil
+}
+
+// Converts `toMap` output (or a Vars map) into string-keyed options.
+func CmdOptsMap(v interface{}) (map[string]interface{}, error) {
+	switch tv := v.(type) {
+	case nil:
+		return nil, nil
+	case vars.Vars:
+		return tv, nil
+	case map[string]interface{}:
+		return tv, nil
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(tv))
+		for k, v := range tv {
+			ret[fmt.Sprint(k)] = v
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("doCmd options: unsupported type %T", v)
+}
+
+/*
+Builds OS environment for a command. vars.Vars are converted into OS environment
+variables with vars.ENVVAR_PREFIX prepended.  `$ZS_WATCHMODE` is only set in
+watch mode.
+*/
+func cmdEnv(mV vars.Vars) []string {
+
+	// write user-defined vars first, built-in vars last,
+	// so that built-ins take precedence
+	env := os.Environ()
+	for k := range mV {
+		if !HasUcase(k) {
+			v := mV.GetStr(k)
+			env = append(env, vars.ENVVAR_PREFIX+strings.ToUpper(k)+"="+v)
+		}
+	}
+	for k := range mV {
+		if HasUcase(k) {
+			v := mV.GetStr(k)
+			if (k == "WATCHMODE") && (len(v) == 0) {
+				continue
+			}
+			env = append(env, vars.ENVVAR_PREFIX+strings.ToUpper(k)+"="+v)
+		}
+	}
+	return env
+}
+
+/*
+runCmd executes a command or a script. vars.Vars define the command environment.
+The command is killed when co.Timeout elapses.
+*/
+func runCmd(co CmdOpts, mV vars.Vars, cmd string, args ...string) (sout, serr []byte, err error) {
+
+	ctx := context.Background()
+	if co.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, co.Timeout)
+		defer cancel()
+	}
+
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Env = cmdEnv(mV)
+	if len(co.chain) > 0 {
+		c.Env = append(c.Env, vars.ENVVAR_CHAIN+"="+co.chain)
+	}
+	c.Dir = co.Dir
+	if len(co.Stdin) > 0 {
+		c.Stdin = strings.NewReader(co.Stdin)
+	}
+
+	// NOTE: don't wait forever on grandchildren holding STDOUT/STDERR open
+	//       after the command itself has been killed
+	c.WaitDelay = time.Second
+
+	var errbuf, outbuf bytes.Buffer
+	c.Stdout = &outbuf
+	c.Stderr = &errbuf
+
+	err = c.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v", co.Timeout)
+	}
+	return outbuf.Bytes(), errbuf.Bytes(), err
+}
+
+func cmdString(cmd string, args ...string) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, s := range append([]string{cmd}, args...) {
+		if strings.ContainsAny(s, " \t\n\"'") {
+			s = strconv.Quote(s)
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+/*
+Runs a command according to `co`, returning text for template output.
+
+On failure, strict mode returns an error (failing the document).
+Otherwise, merged output has the error spliced in, and STDOUT-only output
+reports the error & STDERR through fnWarn.
+*/
+func runCmdOutput(
+	cc CmdCache, co CmdOpts, mV vars.Vars, fnWarn func(error), cmd string, args ...string,
+) (string, error) {
+
+	so, se, err := runCmdCached(cc, co, mV, fnWarn, cmd, args...)
+
+	if err != nil {
+		err = fmt.Errorf("CMD ERROR on `%s`: %w", cmdString(cmd, args...), err)
+		if co.IsStrict {
+			if len(se) > 0 {
+				err = fmt.Errorf("%w\n%s", err, bytes.TrimSpace(se))
+			}
+			return "", err
+		}
+	}
+
+	if co.IsStdoutOnly {
+		if err != nil {
+			fnWarn(err)
+		}
+		if len(se) > 0 {
+			fnWarn(fmt.Errorf("`%s` STDERR:\n%s", cmdString(cmd, args...), bytes.TrimSpace(se)))
+		}
+		return string(so), nil
+	}
+
+	parts := make([]string, 0, 3)
+	if err != nil {
+		parts = append(parts, err.Error())
+	}
+	if len(se) > 0 {
+		parts = append(parts, string(se))
+	}
+	if len(so) > 0 {
+		parts = append(parts, string(so))
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+/*
+Runs a command according to `co`, parsing its STDOUT as co.Parse.
+STDERR is reported through fnWarn, and never mixed into the parsed output.
+Command failures are always errors, since there is no output to fall back on.
+*/
+func runCmdData(
+	cc CmdCache, co CmdOpts, mV vars.Vars, fnWarn func(error), cmd string, args ...string,
+) (interface{}, error) {
+
+	so, se, err := runCmdCached(cc, co, mV, fnWarn, cmd, args...)
+	if err != nil {
+		err = fmt.Errorf("CMD ERROR on `%s`: %w", cmdString(cmd, args...), err)
+		if len(se) > 0 {
+			err = fmt.Errorf("%w\n%s", err, bytes.TrimSpace(se))
+		}
+		return nil, err
+	}
+
+	if len(se) > 0 {
+		fnWarn(fmt.Errorf("`%s` STDERR:\n%s", cmdString(cmd, args...), bytes.TrimSpace(se)))
+	}
+
+	var ret interface{}
+	switch co.Parse {
+	case CMDPARSE_JSON:
+		err = json.Unmarshal(so, &ret)
+	case CMDPARSE_YAML:
+		err = yaml.Unmarshal(so, &ret)
+	default:
+		return string(so), nil
+	}
+	if err != nil {
+		err = fmt.Errorf("`%s` %s output: %w", cmdString(cmd, args...), co.Parse, err)
+	}
+	return ret, err
+}
+
+/*
+Splits a command line into arguments, honoring single quotes, double quotes,
+and backslash escapes (outside of single quotes).  No other shell expansion
+is performed.
+*/
+func SplitCmdLine(line string) ([]string, error) {
+
+	var ret []string
+	var cur strings.Builder
+	var quote rune
+	bInArg, bEsc := false, false
+
+	for _, c := range line {
+		switch {
+		case bEsc:
+			cur.WriteRune(c)
+			bEsc = false
+		case (c == '\\') && (quote != '\''):
+			bEsc, bInArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case (c == '\'') || (c == '"'):
+			quote, bInArg = c, true
+		case unicode.IsSpace(c):
+			if bInArg {
+				ret = append(ret, cur.String())
+				cur.Reset()
+				bInArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			bInArg = true
+		}
+	}
+
+	if bEsc || (quote != 0) {
+		return nil, fmt.Errorf("unterminated quote or escape in `%s`", line)
+	}
+	if bInArg {
+		ret = append(ret, cur.String())
+	}
+	return ret, nil
+}
+
+/*
+Pipes `body` through external filter command `fcmd`, returning its STDOUT.
+Filter failures are always errors.
+*/
+func (oB Builder) runFilter(doc Doc, fcmd string, body []byte, fnWarn func(error)) ([]byte, error) {
+
+	argv, err := SplitCmdLine(fcmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("empty filter command")
+	}
+
+	co := oB.Cmd.OptsFor(doc, oB.SrcRoot)
+	co.Stdin = string(body)
+	co.chain = oB.chainEnv(newTmplChain(), doc.SrcPath)
+
+	so, se, err := runCmdCached(oB.Cmd.Cache, co, doc.Vars, fnWarn, argv[0], argv[1:]...)
+	if err != nil {
+		err = fmt.Errorf("FILTER ERROR on `%s`: %w", fcmd, err)
+		if len(se) > 0 {
+			err = fmt.Errorf("%w\n%s", err, bytes.TrimSpace(se))
+		}
+		return nil, err
+	}
+	if len(se) > 0 {
+		fnWarn(fmt.Errorf("filter `%s` STDERR:\n%s", fcmd, bytes.TrimSpace(se)))
+	}
+	return so, nil
+}
@@ -0,0 +1,366 @@
+package build_test
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+func TestSplitCmdLine(t *testing.T) {
+
+	tests := []struct {
+		line  string
+		sWant []string
+		bErr  bool
+	}{
+		{"", nil, false},
+		{"  \t ", nil, false},
+		{"tr a-z  A-Z", []string{"tr", "a-z", "A-Z"}, false},
+		{`sed 's/a b/c/g'`, []string{"sed", "s/a b/c/g"}, false},
+		{`echo "a 'b' c"`, []string{"echo", "a 'b' c"}, false},
+		{`echo 'a "b" c'`, []string{"echo", `a "b" c`}, false},
+		// escapes are literal inside single quotes only
+		{`echo 'a\b' "a\"b" a\ b`, []string{"echo", `a\b`, `a"b`, "a b"}, false},
+		{`echo \'`, []string{"echo", "'"}, false},
+		// empty args
+		{`echo "" ''`, []string{"echo", "", ""}, false},
+		{`echo a""b`, []string{"echo", "ab"}, false},
+		{`echo 'a`, nil, true},
+		{`echo "a`, nil, true},
+		{`echo a\`, nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.line, func(t *testing.T) {
+			got, err := build.SplitCmdLine(tc.line)
+			if (err != nil) != tc.bErr {
+				t.Fatalf("got error %v, want error = %v", err, tc.bErr)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tc.sWant) {
+				t.Errorf("got %q, want %q", got, tc.sWant)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		filters string // YAML list items
+		want    string // output ("" = failed)
+		sWarn   []string
+		sErr    []string
+	}{
+		{
+			name:    "chain",
+			filters: "  - tr a-z A-Z\n  - sed 's/B/b b/'\n",
+			want:    "Ab bC\n",
+		},
+		{
+			name:    "stderr",
+			filters: "  - sh -c 'cat; echo note >&2'\n",
+			want:    "abc\n",
+			sWarn:   []string{"filter `sh -c 'cat; echo note >&2'` STDERR:\nnote"},
+		},
+		{
+			name:    "exit status",
+			filters: "  - sh -c 'echo oops >&2; exit 3'\n",
+			sErr:    []string{"FILTER ERROR on `sh -c 'echo oops >&2; exit 3'`", "exit status 3", "\noops"},
+		},
+		{
+			name:    "unterminated quote",
+			filters: "  - sed 's/a/b/\n",
+			sErr:    []string{"unterminated quote"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			fsys := fstest.MapFS{
+				".webjot/layout.html": {Data: []byte("{{ doTmpl .DOC_KEY . }}")},
+				"page.html":           {Data: []byte("filters:\n" + tc.filters + "@@@@@@@\nabc\n")},
+			}
+
+			pub, sEvt := buildtest.BuildEvents(t, build.Options{SrcFS: fsys})
+			var sWarn, sErr []string
+			for _, ev := range sEvt {
+				if ev.Kind == build.EVT_WARN {
+					sWarn = append(sWarn, ev.Err.Error())
+				} else {
+					sErr = append(sErr, ev.Err.Error())
+				}
+			}
+
+			if len(sWarn) != len(tc.sWarn) {
+				t.Errorf("got warnings %q, want %q", sWarn, tc.sWarn)
+			}
+			for ix := range sWarn {
+				if (ix < len(tc.sWarn)) && !strings.Contains(sWarn[ix], tc.sWarn[ix]) {
+					t.Errorf("warning %q lacks %q", sWarn[ix], tc.sWarn[ix])
+				}
+			}
+
+			if len(tc.sErr) > 0 {
+				if len(sErr) != 1 {
+					t.Fatalf("got errors %q, want 1", sErr)
+				}
+				for _, s := range tc.sErr {
+					if !strings.Contains(sErr[0], s) {
+						t.Errorf("error %q lacks %q", sErr[0], s)
+					}
+				}
+				return
+			}
+
+			if len(sErr) > 0 {
+				t.Fatalf("got errors %q", sErr)
+			}
+			bs, err := fs.ReadFile(pub, "page.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tc.want {
+				t.Errorf("got %q, want %q", bs, tc.want)
+			}
+		})
+	}
+}
+
+type cmdCase struct {
+	name  string
+	page  string   // sub/page.html
+	want  string   // output (ignored on error)
+	sWarn []string // in warnings, by index
+	sErr  []string // in the (only) error
+}
+
+/*
+Renders each case's page in a site on disk, where `.marker` files in the
+site root, `sub/` & `sub/other/` contain their directory's name.
+*/
+func checkCmdCases(t *testing.T, tests []cmdCase) {
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			root := t.TempDir()
+			for rel, data := range map[string]string{
+				".webjot/layout.html": "{{ doTmpl .DOC_KEY . }}",
+				".marker":             "root",
+				"sub/.marker":         "sub",
+				"sub/other/.marker":   "other",
+				"sub/page.html":       tc.page,
+			} {
+				fpath := filepath.Join(root, filepath.FromSlash(rel))
+				if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(fpath, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pub, sEvt := buildtest.BuildEvents(t, build.Options{SrcDir: root})
+			var sWarn, sErr []string
+			for _, ev := range sEvt {
+				if ev.Kind == build.EVT_WARN {
+					sWarn = append(sWarn, ev.Err.Error())
+				} else {
+					sErr = append(sErr, ev.Err.Error())
+				}
+			}
+
+			if len(sWarn) != len(tc.sWarn) {
+				t.Errorf("got warnings %q, want %q", sWarn, tc.sWarn)
+			}
+			for ix := range sWarn {
+				if (ix < len(tc.sWarn)) && !strings.Contains(sWarn[ix], tc.sWarn[ix]) {
+					t.Errorf("warning %q lacks %q", sWarn[ix], tc.sWarn[ix])
+				}
+			}
+
+			if len(tc.sErr) > 0 {
+				if len(sErr) != 1 {
+					t.Fatalf("got errors %q, want 1", sErr)
+				}
+				for _, s := range tc.sErr {
+					if !strings.Contains(sErr[0], s) {
+						t.Errorf("error %q lacks %q", sErr[0], s)
+					}
+				}
+				return
+			}
+
+			if len(sErr) > 0 {
+				t.Fatalf("got errors %q", sErr)
+			}
+			bs, err := fs.ReadFile(pub, "sub/page.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tc.want {
+				t.Errorf("got %q, want %q", bs, tc.want)
+			}
+		})
+	}
+}
+
+func TestCmdPolicy(t *testing.T) {
+
+	const fail = `"sh" "-c" "echo out; echo err >&2; exit 2"`
+
+	checkCmdCases(t, []cmdCase{
+		{
+			name: "dir doc",
+			page: `{{ doCmd "cat" ".marker" }}`,
+			want: "sub",
+		},
+		{
+			name: "dir root",
+			page: `{{ doCmdWith (toMap "dir" "root") "cat" ".marker" }}`,
+			want: "root",
+		},
+		{
+			name: "dir relative",
+			page: `{{ doCmdWith (toMap "dir" "other") "cat" ".marker" }}`,
+			want: "other",
+		},
+		{
+			name: "stdout & stderr",
+			page: `{{ doCmd "sh" "-c" "echo out; echo err >&2" }}`,
+			want: "err\n\nout\n",
+		},
+		{
+			name:  "stdout only",
+			page:  `{{ doCmdOut "sh" "-c" "echo out; echo err >&2" }}`,
+			want:  "out\n",
+			sWarn: []string{"STDERR:\nerr"},
+		},
+		{
+			name: "exit",
+			page: `{{ doCmd ` + fail + ` }}`,
+			want: "CMD ERROR on `sh -c \"echo out; echo err >&2; exit 2\"`: exit status 2\nerr\n\nout\n",
+		},
+		{
+			name:  "exit stdout only",
+			page:  `{{ doCmdOut ` + fail + ` }}`,
+			want:  "out\n",
+			sWarn: []string{"exit status 2", "STDERR:\nerr"},
+		},
+		{
+			name: "exit strict",
+			page: `{{ doCmdWith (toMap "strict" true) ` + fail + ` }}`,
+			sErr: []string{"CMD ERROR on `sh -c", "exit status 2\nerr"},
+		},
+		{
+			name: "timeout",
+			page: `{{ doCmdWith (toMap "timeout" "200ms") "sleep" "5" }}`,
+			want: "CMD ERROR on `sleep 5`: timed out after 200ms",
+		},
+		{
+			name: "timeout strict",
+			page: `{{ doCmdWith (toMap "timeout" "200ms" "strict" true) "sleep" "5" }}`,
+			sErr: []string{"timed out after 200ms"},
+		},
+		{
+			name: "timeout seconds",
+			page: `{{ doCmdWith (toMap "timeout" 0.2 "strict" true) "sleep" "5" }}`,
+			sErr: []string{"timed out after 200ms"},
+		},
+		{
+			name: "timeout type",
+			page: `{{ doCmdWith (toMap "timeout" true) "true" }}`,
+			sErr: []string{"unsupported type bool (want duration string, or seconds)"},
+		},
+	})
+}
+
+/*
+Timed-out commands are killed, and not waited on past WaitDelay when their
+children hold STDOUT open.
+*/
+func TestCmdTimeoutKill(t *testing.T) {
+
+	tStart := time.Now()
+	checkCmdCases(t, []cmdCase{{
+		name: "grandchild",
+		page: `{{ doCmdWith (toMap "timeout" "100ms") "sh" "-c" "sleep 5 | cat" }}`,
+		want: "CMD ERROR on `sh -c \"sleep 5 | cat\"`: timed out after 100ms",
+	}})
+	if elapsed := time.Since(tStart); elapsed > 3*time.Second {
+		t.Errorf("took %v, want < 3s", elapsed)
+	}
+}
+
+func TestCmdData(t *testing.T) {
+
+	checkCmdCases(t, []cmdCase{
+		{
+			name: "json",
+			page: `{{ with doCmdJSON "echo" "{\"a\": [1, \"x\"]}" }}{{ index .a 1 }}{{ end }}`,
+			want: "x",
+		},
+		{
+			name: "yaml",
+			page: `{{ with doCmdYAML "printf" "a:\n  - 1\n  - x\n" }}{{ index .a 1 }}{{ end }}`,
+			want: "x",
+		},
+		{
+			name: "parse option",
+			page: `{{ with doCmdWith (toMap "parse" "json") "echo" "[2, 3]" }}{{ len . }}{{ end }}`,
+			want: "2",
+		},
+		{
+			name:  "stderr kept out",
+			page:  `{{ with doCmdJSON "sh" "-c" "echo note >&2; echo 5" }}{{ . }}{{ end }}`,
+			want:  "5",
+			sWarn: []string{"STDERR:\nnote"},
+		},
+		{
+			name: "json decode error",
+			page: `{{ doCmdJSON "echo" "{a: 1}" }}`,
+			sErr: []string{"`echo \"{a: 1}\"` json output: invalid character 'a'"},
+		},
+		{
+			name: "yaml decode error",
+			page: `{{ doCmdYAML "printf" "a: [1\n" }}`,
+			sErr: []string{"yaml output: yaml:"},
+		},
+		{
+			name: "exit",
+			page: `{{ doCmdJSON "sh" "-c" "echo oops >&2; exit 1" }}`,
+			sErr: []string{"CMD ERROR on `sh -c", "exit status 1\noops"},
+		},
+		{
+			name: "parse option unknown",
+			page: `{{ doCmdWith (toMap "parse" "toml") "true" }}`,
+			sErr: []string{"unsupported format `toml`"},
+		},
+		{
+			name: "pipe",
+			page: `{{ doCmdPipe "a b\nc\n" "wc" "-l" }}`,
+			want: "2\n",
+		},
+		{
+			name:  "pipe stdout only",
+			page:  `{{ doCmdPipe "abc" "sh" "-c" "tr a-z A-Z; echo err >&2" }}`,
+			want:  "ABC",
+			sWarn: []string{"STDERR:\nerr"},
+		},
+		{
+			name: "stdin option",
+			page: `{{ doCmdWith (toMap "stdin" "abc" "parse" "yaml") "sed" "s/^/k: /" }}`,
+			want: "map[k:abc]",
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+
+	"gopkg.in/yaml.v3"
+)
+
+const CFGFILE = "config.yaml"
+
+/*
+Site-wide settings, read from `<CFGDIR>/config.yaml`.
+*/
+type SiteConfig struct {
+	Strict      bool                  `yaml:"strict"`
+	Fingerprint FingerprintConf       `yaml:"fingerprint"`
+	Minify      []string              `yaml:"minify"`  // output types, i.e. [html, css, js]
+	Bundles     map[string]BundleConf `yaml:"bundles"` // by output path
+	Links       LinkConf              `yaml:"links"`
+	Formats     map[string]FormatConf `yaml:"formats"`
+}
+
+/*
+Loads site configuration from site sources `fsys`.
+A missing config file yields the zero SiteConfig.
+*/
+func LoadSiteConfig(fsys fs.FS) (SiteConfig, error) {
+
+	var ret SiteConfig
+	path := CFGDIR + "/" + CFGFILE
+	bs, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ret, nil
+		}
+		return ret, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(bs))
+	dec.KnownFields(true)
+	// empty file = defaults
+	if err = dec.Decode(&ret); (err != nil) && !errors.Is(err, io.EOF) {
+		return ret, EWrap(err, path)
+	}
+	return ret, nil
+}
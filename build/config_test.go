@@ -0,0 +1,36 @@
+package build_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+)
+
+func TestLoadSiteConfig(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		conf    string
+		bStrict bool
+		bErr    bool
+	}{
+		{"empty", "", false, false},
+		{"comments only", "# nothing yet\n", false, false},
+		{"strict", "strict: true\n", true, false},
+		{"unknown key", "strcit: true\n", false, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{".webjot/config.yaml": {Data: []byte(tc.conf)}}
+			sc, err := build.LoadSiteConfig(fsys)
+			if (err != nil) != tc.bErr {
+				t.Fatalf("err = %v, want error: %v", err, tc.bErr)
+			}
+			if sc.Strict != tc.bStrict {
+				t.Errorf("strict = %v, want %v", sc.Strict, tc.bStrict)
+			}
+		})
+	}
+}
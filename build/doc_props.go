@@ -1,30 +1,34 @@
-package main
+package build
 
 import (
+	"bytes"
 	"io"
-	"os"
+	"io/fs"
 	"regexp"
+
+	"github.com/BourgeoisBear/webjot/vars"
 )
 
 type DocProps struct {
-	SrcPath           string
-	DstPath           string
-	Info              os.FileInfo
+	SrcPath           string // relative to site root, slash-separated
+	DstPath           string // relative to output root, slash-separated
+	Info              fs.FileInfo
 	Source            []byte
-	Vars              Vars
+	BodyLine          int // line number of Source's first line, within the file
+	Vars              vars.Vars
 	NonConformingKeys []string
 }
 
 /*
-Retrieves file contents.
+Retrieves contents of file `path` from fsys.
 Parses YAML above first headerDelim into DocProps.Vars.
 Returns text below headerDelim as DocProps.Source.
 If no headerDelim is found, full contents are returned in DocProps.Source.
 */
-func LoadDocProps(path string, rxHdrDelim *regexp.Regexp) (DocProps, error) {
+func LoadDocProps(fsys fs.FS, path string, rxHdrDelim *regexp.Regexp) (DocProps, error) {
 
-	ret := DocProps{SrcPath: path, Vars: make(Vars)}
-	pf, err := os.Open(path)
+	ret := DocProps{SrcPath: path, Vars: make(vars.Vars), BodyLine: 1}
+	pf, err := fsys.Open(path)
 	if err != nil {
 		return ret, err
 	}
@@ -55,7 +59,8 @@ func LoadDocProps(path string, rxHdrDelim *regexp.Regexp) (DocProps, error) {
 	}
 
 	// found, parse vars from header info
-	ret.Vars, ret.NonConformingKeys, err = ParseHeaderVars(ret.Source[:hdrPos[0]])
+	ret.Vars, ret.NonConformingKeys, err = vars.ParseHeaderVars(ret.Source[:hdrPos[0]])
+	ret.BodyLine += bytes.Count(ret.Source[:hdrPos[1]], []byte("\n"))
 	ret.Source = ret.Source[hdrPos[1]:]
 	return ret, err
 }
@@ -0,0 +1,72 @@
+package build
+
+import "time"
+
+type EventKind uint
+
+const (
+	EVT_BUILD  EventKind = iota // source file compiled or copied
+	EVT_RENDER                  // document rendered into Sink
+	EVT_SKIP                    // output not written (`skip: true`, or image replaced by variants)
+	EVT_CHANGE                  // source change detected in watch mode
+	EVT_WARN                    // non-fatal problem
+	EVT_ERROR                   // file failed to build/render
+	EVT_COPY                    // static file copied into Sink
+)
+
+func (ek EventKind) String() string {
+	switch ek {
+	case EVT_BUILD:
+		return "build"
+	case EVT_RENDER:
+		return "render"
+	case EVT_SKIP:
+		return "skip"
+	case EVT_CHANGE:
+		return "change"
+	case EVT_WARN:
+		return "warn"
+	case EVT_ERROR:
+		return "error"
+	case EVT_COPY:
+		return "copy"
+	}
+	return "unknown"
+}
+
+func (dt DocType) String() string {
+	switch dt {
+	case DT_DOC:
+		return "document"
+	case DT_LAYOUT:
+		return "layout"
+	}
+	return "file"
+}
+
+/*
+Build progress notification.
+Src is relative to the site root (or CFGDIR, for layouts).
+Dst is relative to the output root.
+*/
+type Event struct {
+	Kind    EventKind
+	DocType DocType
+	Src     string
+	Dst     string
+	Msg     string
+	Doc     *Doc
+	Err     error
+	Elapsed time.Duration // time spent building/rendering Src
+}
+
+type EventFunc func(Event)
+
+func (oB Builder) emit(ev Event) {
+	if oB.stats != nil {
+		oB.stats.count(ev.Kind)
+	}
+	if oB.OnEvent != nil {
+		oB.OnEvent(ev)
+	}
+}
@@ -0,0 +1,212 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const DEFAULT_MANIFEST = "asset-manifest.json"
+
+/*
+Opt-in asset fingerprinting, from the `fingerprint` section of site config.
+Output files with extensions in Exts are renamed to `name.<hash>.ext`.
+*/
+type FingerprintConf struct {
+	Exts     []string `yaml:"exts"`     // output extensions, i.e. [.css, .js]
+	Manifest string   `yaml:"manifest"` // original -> fingerprinted paths (default = DEFAULT_MANIFEST)
+}
+
+func (fc FingerprintConf) manifestPath() string {
+	if len(fc.Manifest) > 0 {
+		return strings.TrimPrefix(fc.Manifest, "/")
+	}
+	return DEFAULT_MANIFEST
+}
+
+/*
+Fingerprinted output paths, by original output path.
+*/
+type assetMap map[string]string
+
+/*
+True when fingerprinting is enabled, and applies to output path `dstrel`.
+NOTE: disabled in watch mode, since live.js polls original names.
+*/
+func (oB Builder) isFingerprinted(dstrel string) bool {
+	if oB.IsWatchMode || (oB.assets == nil) {
+		return false
+	}
+	ext := path.Ext(dstrel)
+	for _, fpExt := range oB.Config.Fingerprint.Exts {
+		if strings.EqualFold(ext, fpExt) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+`dir/name.ext` -> `dir/name.<hash>.ext`
+*/
+func fingerprintName(dstrel string, data []byte) string {
+	sum := sha256.Sum256(data)
+	ext := path.Ext(dstrel)
+	return strings.TrimSuffix(dstrel, ext) + "." + hex.EncodeToString(sum[:5]) + ext
+}
+
+/*
+Writes asset `data` into Sink (under its fingerprinted name, when enabled),
+and records its SRI digest.  Returns the output path.
+`info` supplies the mod time for static files (nil = generated); the size
+copied alongside it is always that of `data`, since Sinks skip unchanged
+copies by size & mod time.
+*/
+func (oB Builder) writeAsset(dstrel string, data []byte, info fs.FileInfo) (string, error) {
+
+	outPath := dstrel
+	if oB.isFingerprinted(dstrel) {
+		outPath = fingerprintName(dstrel, data)
+	}
+
+	if info != nil {
+		info = memInfo{name: path.Base(outPath), size: int64(len(data)), modTime: info.ModTime()}
+		if err := oB.Sink.Copy(outPath, bytes.NewReader(data), info); err != nil {
+			return "", err
+		}
+	} else {
+		fDst, err := oB.Sink.Create(outPath)
+		if err != nil {
+			return "", err
+		}
+		_, err = fDst.Write(data)
+		if e2 := fDst.Close(); err == nil {
+			err = e2
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	oB.wrote(outPath)
+	if outPath != dstrel {
+		oB.assets[dstrel] = outPath
+	}
+	if oB.digests != nil {
+		oB.digests[dstrel] = sriDigest(data)
+	}
+	return outPath, nil
+}
+
+/*
+Output path of `dstrel`, after fingerprinting (if any).
+*/
+func (oB Builder) assetPath(dstrel string) string {
+	if fp, ok := oB.assets[dstrel]; ok {
+		return fp
+	}
+	return dstrel
+}
+
+/*
+URL of asset `rel` (an output path, relative to the output root), for the
+`asset` template func.
+*/
+func (oB Builder) assetURL(rel string) (string, error) {
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	if err := oB.useOutput(rel); err != nil {
+		return "", err
+	}
+	if fp, ok := oB.assets[rel]; ok {
+		return "/" + fp, nil
+	}
+	if oB.isFingerprinted(rel) {
+		return "", fmt.Errorf("asset `%s` not found (or not yet built)", rel)
+	}
+	return "/" + rel, nil
+}
+
+/*
+Removes fingerprinted outputs listed in the previous manifest, but no
+longer current (old versions of changed assets).  Needs a Sink that
+can be read back & removed from.
+*/
+func (oB Builder) removeStaleAssets() error {
+
+	rs, ok := oB.Sink.(RemoverSink)
+	pub := oB.Output()
+	if !ok || (pub == nil) {
+		return nil
+	}
+	bs, err := fs.ReadFile(pub, oB.Config.Fingerprint.manifestPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var prev assetMap
+	if err = json.Unmarshal(bs, &prev); err != nil {
+		// not ours, leave it be
+		return nil
+	}
+
+	// only assets re-built this time (partial builds leave others be),
+	// and only names we'd have generated
+	for orig, fp := range prev {
+		cur, ok := oB.assets[orig]
+		if !ok || (cur == fp) || !isFingerprintOf(fp, orig) {
+			continue
+		}
+		if err = rs.Remove(fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+True when `fp` is a fingerprinted name (see fingerprintName) of `dstrel`.
+*/
+func isFingerprintOf(fp, dstrel string) bool {
+	ext := path.Ext(dstrel)
+	base := strings.TrimSuffix(dstrel, ext) + "."
+	if !strings.HasPrefix(fp, base) || !strings.HasSuffix(fp, ext) || (len(fp) != len(base)+10+len(ext)) {
+		return false
+	}
+	_, err := hex.DecodeString(fp[len(base) : len(base)+10])
+	return err == nil
+}
+
+/*
+Writes the asset manifest (JSON object, original -> fingerprinted output
+paths) into Sink, when fingerprinting is enabled, after removing stale
+fingerprinted outputs.
+*/
+func (oB Builder) writeManifest() error {
+	if oB.IsWatchMode || (oB.assets == nil) || (len(oB.Config.Fingerprint.Exts) == 0) {
+		return nil
+	}
+	if err := oB.removeStaleAssets(); err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(oB.assets, "", "\t")
+	if err != nil {
+		return err
+	}
+	fDst, err := oB.Sink.Create(oB.Config.Fingerprint.manifestPath())
+	if err != nil {
+		return err
+	}
+	oB.wrote(oB.Config.Fingerprint.manifestPath())
+	_, err = fDst.Write(append(bs, '\n'))
+	if e2 := fDst.Close(); err == nil {
+		err = e2
+	}
+	return err
+}
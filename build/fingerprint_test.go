@@ -0,0 +1,138 @@
+package build_test
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+/*
+Re-building w/ changed assets removes their old fingerprinted versions.
+*/
+func TestFingerprintStale(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/config.yaml": {Data: []byte("fingerprint:\n  exts: [.css]\n")},
+		".webjot/layout.html": {Data: []byte(`{{ asset "a.css" }} {{ asset "b.css" }}`)},
+		"index.html":          {Data: []byte("x")},
+		"a.css":               {Data: []byte("a { color: red }")},
+		"b.css":               {Data: []byte("b { color: red }")},
+	}
+	snk := build.NewMemSink()
+
+	fnBuild := func() map[string]bool {
+		pB, err := build.New(build.Options{SrcFS: fsys, Sink: snk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pB.Build(); err != nil {
+			t.Fatal(err)
+		}
+		sCss, err := fs.Glob(snk.FS(), "*.css")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make(map[string]bool)
+		for _, s := range sCss {
+			ret[s] = true
+		}
+		return ret
+	}
+
+	mFirst := fnBuild()
+	if len(mFirst) != 2 {
+		t.Fatalf("first build: got %v, want 2 fingerprinted files", mFirst)
+	}
+
+	// unrelated output, w/ a fingerprint-like name
+	w, _ := snk.Create("a.0123456789.txt")
+	w.Close()
+
+	fsys["a.css"] = &fstest.MapFile{Data: []byte("a { color: blue }")}
+	mSecond := fnBuild()
+	if len(mSecond) != 2 {
+		t.Fatalf("second build: got %v, want 2 fingerprinted files", mSecond)
+	}
+	nKept := 0
+	for s := range mFirst {
+		if mSecond[s] {
+			nKept++
+		}
+	}
+	if nKept != 1 {
+		t.Errorf("kept %d of %v in %v, want only b.css", nKept, mFirst, mSecond)
+	}
+	if _, err := fs.Stat(snk.FS(), "a.0123456789.txt"); err != nil {
+		t.Error("removed unrelated output")
+	}
+}
+
+/*
+Assets referenced by other assets (through `asset` & `sri`) render first,
+regardless of path order.
+*/
+func TestAssetOrder(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/config.yaml": {Data: []byte("fingerprint:\n  exts: [.css]\n")},
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"a.css":               {Data: []byte(`@import "{{ asset "b.css" }}"; /* {{ sri "b.css" }} */`)},
+		"b.css":               {Data: []byte(`@import "{{ asset "c.css" }}";`)},
+		"c.css":               {Data: []byte("c { color: red }")},
+		"index.html":          {Data: []byte(`{{ asset "a.css" }}`)},
+	}
+
+	pub := buildtest.Build(t, build.Options{SrcFS: fsys})
+
+	fnRead := func(rel string) string {
+		bs, err := fs.ReadFile(pub, strings.TrimPrefix(rel, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	aCss := fnRead(fnRead("index.html"))
+	sField := strings.Fields(aCss)
+	if len(sField) != 5 {
+		t.Fatalf("a.css: got %q", aCss)
+	}
+	bUrl := strings.Trim(sField[1], `";`)
+	bCss := fnRead(bUrl)
+	sum := sha512.Sum384([]byte(bCss))
+	if want := "sha384-" + base64.StdEncoding.EncodeToString(sum[:]); sField[3] != want {
+		t.Errorf("a.css: got digest %s of b.css, want %s", sField[3], want)
+	}
+	if !strings.HasPrefix(bCss, `@import "/c.`) {
+		t.Errorf("b.css: got %q", bCss)
+	}
+}
+
+func TestAssetCycle(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/config.yaml": {Data: []byte("fingerprint:\n  exts: [.css]\n")},
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"a.css":               {Data: []byte(`{{ asset "b.css" }}`)},
+		"b.css":               {Data: []byte(`{{ sri "a.css" }}`)},
+	}
+
+	_, sEvt := buildtest.BuildEvents(t, build.Options{SrcFS: fsys})
+	var sGot []string
+	for _, ev := range sEvt {
+		sGot = append(sGot, ev.Src+": "+ev.Err.Error())
+	}
+	sWant := []string{
+		"b.css: b.css:1:4: at <sri \"a.css\">: error calling sri: asset cycle: `a.css` references itself (through `asset` or `sri`)",
+		"a.css: a.css:1:4: at <asset \"b.css\">: error calling asset: `b.css` failed to build",
+	}
+	if strings.Join(sGot, "\n") != strings.Join(sWant, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(sGot, "\n"), strings.Join(sWant, "\n"))
+	}
+}
@@ -0,0 +1,195 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+/*
+Converts template-expanded source `src` of `doc` into its output format.
+*/
+type ConvertFunc func(dst io.Writer, src []byte, doc Doc) error
+
+/*
+Site access for built-in conversions (i.e. markdown image sizes, SCSS
+imports), bound to the document being converted.
+*/
+type convCtx struct {
+	fsys   fs.FS
+	src    string // document's source path, relative to fsys root
+	fnWarn func(error)
+	deps   depMap // receives imported files
+}
+
+/*
+Conversion context for document `tmplName` (nil when there's no document).
+*/
+func (oB Builder) convCtxFor(tmplName string, fnWarn func(error)) *convCtx {
+	if (len(tmplName) == 0) || (oB.SrcFS == nil) {
+		return nil
+	}
+	return &convCtx{fsys: oB.SrcFS, src: tmplName, fnWarn: fnWarn, deps: oB.deps}
+}
+
+func (cc *convCtx) warn(err error) {
+	if cc.fnWarn != nil {
+		cc.fnWarn(err)
+	}
+}
+
+/*
+Describes how source files with a given extension are built.
+Files with unregistered extensions are copied as-is.
+*/
+type Format struct {
+	Ext          string      // source extension, lowercase, with leading dot
+	OutExt       string      // output extension ("" = same as Ext)
+	IsTemplate   bool        // expand source as a text/template
+	IsLayoutable bool        // render inside a layout, list in `docsAll`
+	Convert      ConvertFunc // built-in conversion (nil = none)
+	Command      string      // external conversion, via STDIN/STDOUT ("" = none)
+}
+
+// True when source must be processed, rather than copied.
+func (f Format) IsProcessed() bool {
+	return f.IsTemplate || f.HasConversion() || (f.OutExt != f.Ext)
+}
+
+func (f Format) HasConversion() bool {
+	return (f.Convert != nil) || (len(f.Command) > 0)
+}
+
+// Source extension -> Format.
+type Formats map[string]Format
+
+func DefaultFormats() Formats {
+
+	fnMd := func(dst io.Writer, src []byte, doc Doc) error {
+		return md2html(dst, src, doc.conv)
+	}
+
+	fnScss := func(dst io.Writer, src []byte, doc Doc) error {
+		return doc.conv.compileScss(dst, src, doc)
+	}
+
+	fnGcss := func(dst io.Writer, src []byte, doc Doc) error {
+		return doc.conv.compileGcss(dst, src, doc)
+	}
+
+	mF := make(Formats)
+	for _, f := range []Format{
+		{Ext: ".html", IsTemplate: true, IsLayoutable: true},
+		{Ext: ".htm", IsTemplate: true, IsLayoutable: true},
+		{Ext: ".xml", IsTemplate: true, IsLayoutable: true},
+		{Ext: ".md", OutExt: ".html", IsTemplate: true, IsLayoutable: true, Convert: fnMd},
+		{Ext: ".css", IsTemplate: true},
+		{Ext: ".gcss", OutExt: ".css", IsTemplate: true, Convert: fnGcss},
+		{Ext: ".scss", OutExt: ".css", IsTemplate: true, Convert: fnScss},
+	} {
+		mF.Register(f)
+	}
+	return mF
+}
+
+/*
+Adds or replaces the handler for f.Ext.
+*/
+func (mF Formats) Register(f Format) {
+	f.Ext = strings.ToLower(f.Ext)
+	if len(f.OutExt) == 0 {
+		f.OutExt = f.Ext
+	}
+	mF[f.Ext] = f
+}
+
+func (mF Formats) Get(ext string) (Format, bool) {
+	f, ok := mF[strings.ToLower(ext)]
+	return f, ok
+}
+
+func (mF Formats) IsTemplateExt(ext string) bool {
+	f, ok := mF.Get(ext)
+	return ok && f.IsTemplate
+}
+
+func (mF Formats) IsLayoutableExt(ext string) bool {
+	f, ok := mF.Get(ext)
+	return ok && f.IsLayoutable
+}
+
+/*
+True for extensions that may be used as layouts in CFGDIR:
+layoutable templates that are output as-is.
+*/
+func (mF Formats) IsLayoutExt(ext string) bool {
+	f, ok := mF.Get(ext)
+	return ok && f.IsTemplate && f.IsLayoutable && !f.HasConversion() && (f.OutExt == f.Ext)
+}
+
+/*
+Returns output extension for source extension `ext`.
+Unregistered extensions are unchanged.
+*/
+func (mF Formats) OutExt(ext string) string {
+	if f, ok := mF.Get(ext); ok {
+		return f.OutExt
+	}
+	return ext
+}
+
+/*
+Site configuration entry for a source format, i.e.
+
+	formats:
+	  .txt:
+	    template: true
+	  .adoc:
+	    output: .html
+	    layout: true
+	    command: asciidoctor -s -o - -
+*/
+type FormatConf struct {
+	Output   string `yaml:"output"`
+	Template *bool  `yaml:"template"`
+	Layout   *bool  `yaml:"layout"`
+	Command  string `yaml:"command"`
+}
+
+/*
+Registers formats from site configuration.  Keys for existing formats
+modify only the fields that are specified.
+*/
+func (mF Formats) Configure(mConf map[string]FormatConf) error {
+
+	for ext, fc := range mConf {
+
+		if !strings.HasPrefix(ext, ".") || (len(ext) < 2) {
+			return fmt.Errorf("formats: invalid extension `%s`", ext)
+		}
+		if (len(fc.Output) > 0) && !strings.HasPrefix(fc.Output, ".") {
+			return fmt.Errorf("formats: %s: invalid output extension `%s`", ext, fc.Output)
+		}
+
+		f, ok := mF.Get(ext)
+		if !ok {
+			f = Format{Ext: ext, IsTemplate: true}
+		}
+		if len(fc.Output) > 0 {
+			f.OutExt = strings.ToLower(fc.Output)
+		}
+		if fc.Template != nil {
+			f.IsTemplate = *fc.Template
+		}
+		if fc.Layout != nil {
+			f.IsLayoutable = *fc.Layout
+		}
+		if len(fc.Command) > 0 {
+			f.Convert = nil
+			f.Command = fc.Command
+		}
+		mF.Register(f)
+	}
+	return nil
+}
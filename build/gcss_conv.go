@@ -0,0 +1,183 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yosssi/gcss"
+)
+
+/*
+Imported stylesheets: dependents (documents), by imported file.
+Both are source paths, relative to the site root.
+*/
+type depMap map[string]map[string]bool
+
+func (dm depMap) add(dep, doc string) {
+	if dm == nil {
+		return
+	}
+	if dm[dep] == nil {
+		dm[dep] = make(map[string]bool)
+	}
+	dm[dep][doc] = true
+}
+
+/*
+Documents that import `dep`, sorted.
+*/
+func (dm depMap) dependents(dep string) []string {
+	var ret []string
+	for doc := range dm[dep] {
+		ret = append(ret, doc)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+Resolves stylesheet import `name` (w/ extension `ext`) from file `from`:
+looks beside `from`, then in CFGDIR, for `_name.ext` & `name.ext`.
+Resolved files are recorded as dependencies of the document.
+*/
+func (cc *convCtx) findImport(name, from, ext string) (string, []byte, error) {
+
+	dir, base := path.Split(name)
+	base = strings.TrimSuffix(base, ext)
+
+	var sTried []string
+	for _, root := range []string{path.Dir(from), CFGDIR} {
+		for _, cand := range []string{"_" + base + ext, base + ext} {
+			p := path.Join(root, dir, cand)
+			bs, err := fs.ReadFile(cc.fsys, p)
+			if err == nil {
+				cc.deps.add(p, cc.src)
+				return p, bs, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", nil, err
+			}
+			sTried = append(sTried, p)
+		}
+	}
+	return "", nil, fmt.Errorf("can't find import `%s` (tried %s)", name, strings.Join(sTried, ", "))
+}
+
+// `@import "name"` (quotes optional)
+var rxGcssImport = regexp.MustCompile(`^(\s*)@import\s+(?:"([^"]*)"|'([^']*)'|(\S+?));?\s*$`)
+
+// gcss errors end w/ `[line: N]`
+var rxGcssErr = regexp.MustCompile(`^(.*?)\s*\[line: (\d+)\]$`)
+
+type srcLine struct {
+	file string
+	line int
+}
+
+/*
+GCSS source, w/ imports inlined, and the origin of each line.
+*/
+type gcssSource struct {
+	sLines  []string
+	sOrigin []srcLine
+}
+
+/*
+Appends the lines of `src` (file `from`) to gs, inlining `@import`s of
+other GCSS files at the indent of the `@import`.  CSS imports (`url()`,
+`.css`, or remote) are kept.
+*/
+func (cc *convCtx) gcssExpand(gs *gcssSource, src []byte, from, indent string, sStack []string) error {
+
+	for ix, ln := range strings.Split(string(src), "\n") {
+		m := rxGcssImport.FindStringSubmatch(ln)
+		name := ""
+		if m != nil {
+			name = m[2] + m[3] + m[4]
+		}
+		bCSS := strings.HasPrefix(name, "url(") || strings.HasSuffix(name, ".css") ||
+			strings.Contains(name, "//")
+		if (m == nil) || bCSS {
+			gs.sLines = append(gs.sLines, indent+ln)
+			gs.sOrigin = append(gs.sOrigin, srcLine{file: from, line: ix + 1})
+			continue
+		}
+
+		fnErr := func(err error) error {
+			return &TemplateError{File: from, Line: ix + 1, Msg: err.Error(), Excerpt: ln}
+		}
+		p, bs, err := cc.findImport(name, from, ".gcss")
+		if err != nil {
+			return fnErr(err)
+		}
+		for _, s := range sStack {
+			if s == p {
+				return fnErr(fmt.Errorf("import cycle: %s", strings.Join(append(sStack, p), CHAIN_SEP)))
+			}
+		}
+		if err = cc.gcssExpand(gs, bs, p, indent+m[1], append(sStack, p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Locates a gcss error within the original sources.
+*/
+func (gs gcssSource) locate(err error) error {
+	m := rxGcssErr.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	n, _ := strconv.Atoi(m[2])
+	if (n < 1) || (n > len(gs.sOrigin)) {
+		return err
+	}
+	orig := gs.sOrigin[n-1]
+	return &TemplateError{File: orig.file, Line: orig.line, Msg: m[1], Excerpt: gs.sLines[n-1]}
+}
+
+/*
+Adjusts errors in `doc` for its header, and formats excerpts.
+*/
+func docErr(err error, doc Doc) error {
+	var te *TemplateError
+	if !errors.As(err, &te) {
+		return err
+	}
+	if te.File == doc.TmplName {
+		te.Line += doc.BodyLine - 1
+	}
+	te.Excerpt = excerpt(te.Excerpt, te.Line, te.Col)
+	return te
+}
+
+/*
+Compiles template-expanded GCSS `src` of `doc` into CSS.
+*/
+func (cc *convCtx) compileGcss(dst io.Writer, src []byte, doc Doc) error {
+
+	if cc == nil {
+		_, err := gcss.Compile(dst, bytes.NewReader(src))
+		return err
+	}
+
+	var gs gcssSource
+	if err := cc.gcssExpand(&gs, src, doc.TmplName, "", []string{doc.TmplName}); err != nil {
+		return docErr(err, doc)
+	}
+	_, err := gcss.Compile(dst, strings.NewReader(strings.Join(gs.sLines, "\n")))
+	if err != nil {
+		return docErr(gs.locate(err), doc)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package build_test
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+/*
+GCSS errors are located in the (imported) file they occur in.
+*/
+func TestGcssErrors(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		// compile errors
+		"css/main.gcss":  {Data: []byte("title: Main\n@@@@@@@\nh1\n  margin: 0\n@import part\nh2\n    margin: 0\n  padding: 0\n")},
+		"css/_part.gcss": {Data: []byte("p\n  color: blue\nul\n    margin: 0\n  padding: 0\n")},
+		"css/outer.gcss": {Data: []byte("h1\n  margin: 0\n  @import part\n")},
+		"css/head.gcss":  {Data: []byte("title: Head\n@@@@@@@\nh1\n  margin: 0\nh2\n    margin: 0\n  padding: 0\n")},
+		// imports
+		"css/a.gcss":    {Data: []byte("h1\n  margin: 0\n@import b\n")},
+		"css/_b.gcss":   {Data: []byte("\n@import 'a'\n")},
+		"css/self.gcss": {Data: []byte("@import \"self\";\n")},
+		"css/none.gcss": {Data: []byte("h1\n  margin: 0\n\n@import none/x\n")},
+	}
+
+	_, sEvt := buildtest.BuildEvents(t, build.Options{SrcFS: fsys})
+	mGot := make(map[string]*build.TemplateError)
+	for _, ev := range sEvt {
+		var te *build.TemplateError
+		if !errors.As(ev.Err, &te) {
+			t.Errorf("[%s] got %T (%v), want *build.TemplateError", ev.Src, ev.Err, ev.Err)
+			continue
+		}
+		mGot[ev.Src] = te
+	}
+
+	mWant := map[string]string{
+		"css/main.gcss":  "css/_part.gcss:4: indent is invalid",
+		"css/outer.gcss": "css/_part.gcss:4: indent is invalid",
+		"css/head.gcss":  "css/head.gcss:6: indent is invalid",
+		"css/a.gcss":     "css/_b.gcss:2: import cycle: css/a.gcss -> css/_b.gcss -> css/a.gcss",
+		"css/self.gcss":  "css/self.gcss:1: import cycle: css/self.gcss -> css/self.gcss",
+		"css/none.gcss": "css/none.gcss:4: can't find import `none/x` (tried css/none/_x.gcss, " +
+			"css/none/x.gcss, .webjot/none/_x.gcss, .webjot/none/x.gcss)",
+	}
+	for src, want := range mWant {
+		te, ok := mGot[src]
+		if !ok {
+			t.Errorf("[%s] no error, want %q", src, want)
+			continue
+		}
+		if got := te.Error(); got != want {
+			t.Errorf("[%s] got %q, want %q", src, got, want)
+		}
+	}
+	if te := mGot["css/main.gcss"]; (te != nil) && (te.Excerpt != "4 |     margin: 0") {
+		t.Errorf("[css/main.gcss] got excerpt %q", te.Excerpt)
+	}
+	if len(mGot) != len(mWant) {
+		t.Errorf("got %d errors, want %d", len(mGot), len(mWant))
+	}
+}
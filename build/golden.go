@@ -0,0 +1,143 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+/*
+Marks a directory written by WriteGolden, which it may replace.
+*/
+const GOLDEN_MARKER = ".webjot-golden"
+
+/*
+A difference between built output and a golden file.
+*/
+type GoldenDiff struct {
+	Path string // slash-separated, relative to output root
+	Msg  string
+}
+
+func (gd GoldenDiff) String() string {
+	return fmt.Sprintf("%s: %s", gd.Path, gd.Msg)
+}
+
+func readAllFiles(fsys fs.FS) (map[string][]byte, error) {
+	ret := make(map[string][]byte)
+	err := fs.WalkDir(fsys, ".", func(rel string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		bs, err := fs.ReadFile(fsys, rel)
+		if err != nil {
+			return err
+		}
+		ret[rel] = bs
+		return nil
+	})
+	return ret, err
+}
+
+/*
+Describes the first differing line between `want` and `got`.
+*/
+func firstLineDiff(want, got []byte) string {
+	sW := bytes.Split(want, []byte("\n"))
+	sG := bytes.Split(got, []byte("\n"))
+	for i := 0; ; i++ {
+		var lW, lG []byte
+		if i < len(sW) {
+			lW = sW[i]
+		}
+		if i < len(sG) {
+			lG = sG[i]
+		}
+		if (i >= len(sW)) || (i >= len(sG)) || !bytes.Equal(lW, lG) {
+			return fmt.Sprintf("differs at line %d\n\twant: %q\n\tgot:  %q", i+1, lW, lG)
+		}
+	}
+}
+
+/*
+Compares every file in `got` (i.e. Builder.Output()) against the files
+in `want`.  Results are sorted by path.
+*/
+func DiffFS(got, want fs.FS) ([]GoldenDiff, error) {
+
+	mGot, err := readAllFiles(got)
+	if err != nil {
+		return nil, EWrap(err, "read output")
+	}
+	mWant, err := readAllFiles(want)
+	if err != nil {
+		return nil, EWrap(err, "read golden")
+	}
+	delete(mWant, GOLDEN_MARKER)
+
+	var ret []GoldenDiff
+	for rel, bsW := range mWant {
+		bsG, ok := mGot[rel]
+		switch {
+		case !ok:
+			ret = append(ret, GoldenDiff{Path: rel, Msg: "missing from output"})
+		case !bytes.Equal(bsW, bsG):
+			ret = append(ret, GoldenDiff{Path: rel, Msg: firstLineDiff(bsW, bsG)})
+		}
+	}
+	for rel := range mGot {
+		if _, ok := mWant[rel]; !ok {
+			ret = append(ret, GoldenDiff{Path: rel, Msg: "not in golden"})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+/*
+Replaces the contents of golden dir `dst` with the files in `got`, and
+marks it w/ GOLDEN_MARKER.  To avoid clobbering unrelated trees, `dst`
+must be missing, empty, or already marked.
+*/
+func WriteGolden(got fs.FS, dst string, dirMode, fileMode os.FileMode) error {
+
+	sEnt, err := os.ReadDir(dst)
+	if (err != nil) && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(sEnt) > 0 {
+		if _, err = os.Stat(filepath.Join(dst, GOLDEN_MARKER)); err != nil {
+			return fmt.Errorf("`%s` is not a golden dir (not empty, and no %s)", dst, GOLDEN_MARKER)
+		}
+		if err = os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+
+	mGot, err := readAllFiles(got)
+	if err != nil {
+		return EWrap(err, "read output")
+	}
+	if len(mGot) == 0 {
+		return errors.New("no output to write")
+	}
+	mGot[GOLDEN_MARKER] = nil
+	for rel, bs := range mGot {
+		path := filepath.Join(dst, filepath.FromSlash(rel))
+		if err = os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+			return err
+		}
+		if err = os.WriteFile(path, bs, fileMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package build_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+var bUpdate = flag.Bool("update", false, "re-write golden output from fixture sites")
+
+/*
+Builds each site in testdata/sites/, and compares its output against
+testdata/golden/<site>/.
+*/
+func TestGoldenSites(t *testing.T) {
+
+	sEnts, err := os.ReadDir(filepath.Join("testdata", "sites"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, de := range sEnts {
+		if !de.IsDir() {
+			continue
+		}
+		name := de.Name()
+		t.Run(name, func(t *testing.T) {
+			buildtest.CheckGolden(t,
+				filepath.Join("testdata", "sites", name),
+				filepath.Join("testdata", "golden", name),
+				*bUpdate,
+			)
+		})
+	}
+}
+
+/*
+WriteGolden only replaces missing, empty, or previously written dirs.
+*/
+func TestWriteGolden(t *testing.T) {
+
+	got := fstest.MapFS{"index.html": {Data: []byte("hi")}}
+	fnWrite := func(dst string) error {
+		return build.WriteGolden(got, dst, 0755, 0644)
+	}
+	fnExists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	// unrelated tree
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "notes.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fnWrite(other); err == nil {
+		t.Error("replaced a dir w/o golden marker")
+	}
+	if !fnExists(filepath.Join(other, "notes.txt")) {
+		t.Fatal("unrelated file removed")
+	}
+
+	// missing & empty dirs
+	for _, dst := range []string{filepath.Join(t.TempDir(), "new"), t.TempDir()} {
+		if err := fnWrite(dst); err != nil {
+			t.Fatal(err)
+		}
+		if !fnExists(filepath.Join(dst, build.GOLDEN_MARKER)) {
+			t.Errorf("%s: no golden marker", dst)
+		}
+	}
+
+	// marked dir: replaced, stale files removed
+	dst := t.TempDir()
+	if err := fnWrite(dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "stale.html"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fnWrite(dst); err != nil {
+		t.Fatal(err)
+	}
+	if fnExists(filepath.Join(dst, "stale.html")) {
+		t.Error("stale golden file kept")
+	}
+
+	// marker isn't part of the comparison
+	sDiff, err := build.DiffFS(got, os.DirFS(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sDiff) > 0 {
+		t.Errorf("unexpected diffs: %v", sDiff)
+	}
+}
@@ -0,0 +1,235 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const IMG_JPEG_QUALITY = 85
+
+/*
+A resized image variant, as returned by the `imgResize` template func.
+Renders as its URL.
+*/
+type Image struct {
+	URL    string
+	Path   string // output path, relative to the output root
+	Width  int
+	Height int
+}
+
+func (im Image) String() string {
+	return im.URL
+}
+
+/*
+Resized variants of one image, as returned by the `imgSrcset` template func.
+Renders as its `srcset` attribute value.
+*/
+type ImageSet struct {
+	Images []Image // by ascending width
+	Src    Image   // widest variant, for the `src` attribute
+	Srcset string  // `url 400w, url 800w, ...`
+}
+
+func (is ImageSet) String() string {
+	return is.Srcset
+}
+
+/*
+`dir/name.ext` -> `dir/name.<width>w.ext`
+*/
+func imgVariantName(rel string, width int) string {
+	ext := path.Ext(rel)
+	return strings.TrimSuffix(rel, ext) + "." + strconv.Itoa(width) + "w" + ext
+}
+
+/*
+Scales `src` to `width`, preserving aspect ratio.  Never upscales.
+*/
+func imgScale(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if width >= b.Dx() {
+		return src
+	}
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func imgEncode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: IMG_JPEG_QUALITY})
+	case "png":
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("unsupported image format `%s`", format)
+	}
+	return buf.Bytes(), err
+}
+
+/*
+Cached variant path, under `<CFGDIR>/cache/img/`.
+*/
+func (oB Builder) imgCachePath(key, ext string) string {
+	if !oB.Cmd.Cache.IsEnabled() {
+		return ""
+	}
+	return filepath.Join(oB.Cmd.Cache.Dir, "img", key[:2], key+ext)
+}
+
+/*
+Encoded variant of source image `bs` at `width`, from cache when available.
+*/
+func (oB Builder) imgVariantData(bs []byte, key, ext string, width int) ([]byte, error) {
+
+	cpath := oB.imgCachePath(key, ext)
+	if len(cpath) > 0 {
+		if data, err := os.ReadFile(cpath); err == nil {
+			return data, nil
+		}
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	data, err := imgEncode(imgScale(src, width), format)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cpath) > 0 {
+		if err = writeCacheFile(cpath, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+/*
+Writes a variant of source image `rel` (relative to the site root), scaled
+to `width` (or to its original width, when smaller), into Sink.
+Variants are written once per build, and cached across builds.
+*/
+func (oB Builder) imgResize(rel string, width int) (Image, error) {
+
+	if width <= 0 {
+		return Image{}, fmt.Errorf("invalid image width %d", width)
+	}
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	bs, err := fs.ReadFile(oB.SrcFS, rel)
+	if err != nil {
+		return Image{}, err
+	}
+	if _, ok := oB.imgRefs[rel]; !ok && (oB.imgRefs != nil) {
+		oB.imgRefs[rel] = true
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(bs))
+	if err != nil {
+		return Image{}, EWrap(err, rel)
+	}
+	if width > cfg.Width {
+		width = cfg.Width
+	}
+
+	// key on content & encoder settings
+	h := sha256.New()
+	h.Write(bs)
+	fmt.Fprintf(h, "\x00%d\x00%d", width, IMG_JPEG_QUALITY)
+	key := hex.EncodeToString(h.Sum(nil))
+	if im, ok := oB.images[key]; ok {
+		return im, nil
+	}
+
+	ext := path.Ext(rel)
+	data, err := oB.imgVariantData(bs, key, ext, width)
+	if err != nil {
+		return Image{}, EWrap(err, rel)
+	}
+	vcfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, EWrap(err, rel)
+	}
+
+	// mod time follows source, so unchanged variants aren't re-copied
+	info, err := fs.Stat(oB.SrcFS, rel)
+	if err != nil {
+		return Image{}, err
+	}
+	dst := imgVariantName(rel, width)
+	outPath, err := oB.writeAsset(dst, data, memInfo{
+		name: path.Base(dst), size: int64(len(data)), modTime: info.ModTime(),
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	im := Image{
+		URL:    "/" + outPath,
+		Path:   outPath,
+		Width:  vcfg.Width,
+		Height: vcfg.Height,
+	}
+	if oB.images != nil {
+		oB.images[key] = im
+	}
+	return im, nil
+}
+
+/*
+Writes variants of source image `rel` at each of `widths`, for the
+`imgSrcset` template func.
+*/
+func (oB Builder) imgSrcset(rel string, widths ...int) (ImageSet, error) {
+
+	if len(widths) == 0 {
+		return ImageSet{}, fmt.Errorf("imgSrcset `%s`: no widths given", rel)
+	}
+
+	var ret ImageSet
+	mSeen := make(map[string]bool)
+	for _, w := range widths {
+		im, err := oB.imgResize(rel, w)
+		if err != nil {
+			return ImageSet{}, err
+		}
+		// widths past the original collapse into one variant
+		if !mSeen[im.Path] {
+			mSeen[im.Path] = true
+			ret.Images = append(ret.Images, im)
+		}
+	}
+
+	sort.Slice(ret.Images, func(i, j int) bool {
+		return ret.Images[i].Width < ret.Images[j].Width
+	})
+	sSet := make([]string, len(ret.Images))
+	for i, im := range ret.Images {
+		sSet[i] = fmt.Sprintf("%s %dw", im.URL, im.Width)
+	}
+	ret.Src = ret.Images[len(ret.Images)-1]
+	ret.Srcset = strings.Join(sSet, ", ")
+	return ret, nil
+}
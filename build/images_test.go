@@ -0,0 +1,200 @@
+package build_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+func TestImageVariants(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"a.png":               {Data: testPNG(t, 100, 60)},
+		"resize.html": {Data: []byte(
+			`{{ with imgResize "a.png" 50 }}{{ . }} {{ .Width }}x{{ .Height }}{{ end }}` + "\n" +
+				`{{ with imgResize "/a.png" 200 }}{{ . }} {{ .Width }}x{{ .Height }}{{ end }}`,
+		)},
+		"srcset.html": {Data: []byte(
+			`{{ $s := imgSrcset "a.png" 20 50 200 300 }}{{ $s }}` + "\n" +
+				`{{ $s.Src }} {{ $s.Src.Width }}x{{ $s.Src.Height }} {{ len $s.Images }}`,
+		)},
+	}
+
+	pub := buildtest.Build(t, build.Options{SrcFS: fsys})
+
+	fnRead := func(rel string) string {
+		bs, err := fs.ReadFile(pub, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	tests := []struct {
+		page string
+		want []string // by line
+	}{
+		{"resize.html", []string{
+			"/a.50w.png 50x30",
+			// never upscaled
+			"/a.100w.png 100x60",
+		}},
+		{"srcset.html", []string{
+			// widths past the original collapse into one variant
+			"/a.20w.png 20w, /a.50w.png 50w, /a.100w.png 100w",
+			"/a.100w.png 100x60 3",
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.page, func(t *testing.T) {
+			if got := strings.Split(fnRead(tc.page), "\n"); strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// written variants decode at their reported size
+	for rel, want := range map[string]image.Point{
+		"a.20w.png":  {20, 12},
+		"a.50w.png":  {50, 30},
+		"a.100w.png": {100, 60},
+	} {
+		cfg, err := png.DecodeConfig(strings.NewReader(fnRead(rel)))
+		if err != nil {
+			t.Fatalf("%s: %v", rel, err)
+		}
+		if (cfg.Width != want.X) || (cfg.Height != want.Y) {
+			t.Errorf("%s: %dx%d, want %dx%d", rel, cfg.Width, cfg.Height, want.X, want.Y)
+		}
+	}
+	if _, err := fs.Stat(pub, "a.200w.png"); err == nil {
+		t.Error("upscaled variant a.200w.png written")
+	}
+}
+
+func testPNG(tb testing.TB, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+/*
+Originals only used through imgResize are replaced by their variants.
+*/
+func TestImageOriginals(t *testing.T) {
+
+	// NOTE: variants are keyed on content, so sources differ
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"resized.png":         {Data: testPNG(t, 100, 60)},
+		"linked.png":          {Data: testPNG(t, 100, 61)},
+		"both.png":            {Data: testPNG(t, 100, 62)},
+		"unused.png":          {Data: testPNG(t, 100, 63)},
+		"index.html": {Data: []byte(
+			`{{ imgResize "resized.png" 50 }} {{ asset "linked.png" }} ` +
+				`{{ imgResize "both.png" 50 }} {{ sri "both.png" }}`,
+		)},
+	}
+
+	var sSkip []string
+	pub := buildtest.Build(t, build.Options{
+		SrcFS: fsys,
+		OnEvent: func(ev build.Event) {
+			if ev.Kind == build.EVT_SKIP {
+				sSkip = append(sSkip, ev.Src)
+			}
+		},
+	})
+
+	for rel, bWant := range map[string]bool{
+		"resized.png":     false,
+		"resized.50w.png": true,
+		"linked.png":      true,
+		"both.png":        true,
+		"both.50w.png":    true,
+		"unused.png":      true,
+	} {
+		if _, err := fs.Stat(pub, rel); (err == nil) != bWant {
+			t.Errorf("%s: written = %v, want %v", rel, err == nil, bWant)
+		}
+	}
+	if strings.Join(sSkip, ",") != "resized.png" {
+		t.Errorf("skipped %q, want [resized.png]", sSkip)
+	}
+}
+
+/*
+Variants written while a page renders don't interrupt its zip entry.
+*/
+func TestImageVariantsZip(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte(`<p>{{ doTmpl .DOC_KEY . }}</p>`)},
+		"a.png":               {Data: testPNG(t, 100, 60)},
+		"index.html":          {Data: []byte(`{{ imgResize "a.png" 50 }} {{ imgResize "a.png" 20 }}`)},
+	}
+
+	var buf bytes.Buffer
+	zs := build.NewZipSink(&buf)
+	pB, err := build.New(build.Options{
+		SrcFS: fsys,
+		Sink:  zs,
+		OnEvent: func(ev build.Event) {
+			if ev.Kind == build.EVT_ERROR {
+				t.Errorf("[%s] %v", ev.Src, ev.Err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pB.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if err = zs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mGot := make(map[string]string)
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mGot[zf.Name] = string(bs)
+	}
+
+	if got, want := mGot["index.html"], "<p>/a.50w.png /a.20w.png</p>"; got != want {
+		t.Errorf("index.html: got %q, want %q", got, want)
+	}
+	for _, rel := range []string{"a.50w.png", "a.20w.png"} {
+		if _, err := png.DecodeConfig(strings.NewReader(mGot[rel])); err != nil {
+			t.Errorf("%s: %v", rel, err)
+		}
+	}
+	if _, ok := mGot["a.png"]; ok {
+		t.Error("original a.png written")
+	}
+}
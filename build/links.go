@@ -0,0 +1,330 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+/*
+Link check settings, from `links:` in site config.
+*/
+type LinkConf struct {
+	External []string `yaml:"external"` // allowed external URL prefixes (empty = external links unchecked)
+	Ignore   []string `yaml:"ignore"`   // URL prefixes never checked
+}
+
+/*
+Attributes holding URLs, by element.
+*/
+var linkAttrs = map[string][]string{
+	"a":      {"href"},
+	"area":   {"href"},
+	"link":   {"href"},
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"embed":  {"src"},
+	"audio":  {"src"},
+	"video":  {"src", "poster"},
+	"track":  {"src"},
+}
+
+type pageLink struct {
+	url  string
+	line int // within the output page
+}
+
+/*
+Element IDs & outbound links of an HTML output page.
+*/
+type pageLinks struct {
+	ids   map[string]bool
+	links []pageLink
+}
+
+/*
+Collects IDs (and `<a name>`) & URL attributes from HTML `r`.
+*/
+func scanPage(r io.Reader) (pageLinks, error) {
+
+	ret := pageLinks{ids: make(map[string]bool)}
+	z := html.NewTokenizer(r)
+	line := 1
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if errors.Is(z.Err(), io.EOF) {
+				return ret, nil
+			}
+			return ret, z.Err()
+		}
+		tagLine := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+		if (tt != html.StartTagToken) && (tt != html.SelfClosingTagToken) {
+			continue
+		}
+
+		tok := z.Token()
+		for _, attr := range tok.Attr {
+			switch {
+			case attr.Key == "id", (tok.Data == "a") && (attr.Key == "name"):
+				ret.ids[attr.Val] = true
+				continue
+			}
+			bLink := false
+			for _, k := range linkAttrs[tok.Data] {
+				bLink = bLink || (k == attr.Key)
+			}
+			if !bLink {
+				continue
+			}
+			if attr.Key != "srcset" {
+				ret.links = append(ret.links, pageLink{url: attr.Val, line: tagLine})
+				continue
+			}
+			// `url [descriptor], ...`
+			for _, cand := range strings.Split(attr.Val, ",") {
+				if fields := strings.Fields(cand); len(fields) > 0 {
+					ret.links = append(ret.links, pageLink{url: fields[0], line: tagLine})
+				}
+			}
+		}
+	}
+}
+
+/*
+Checks link `raw`, from output page `page`, against the outputs of the
+current Build().  Returns "" when it's ok.
+*/
+func (oB Builder) checkLink(mPages map[string]pageLinks, page, raw string) string {
+
+	raw = strings.TrimSpace(raw)
+	for _, pfx := range oB.Config.Links.Ignore {
+		if strings.HasPrefix(raw, pfx) {
+			return ""
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "malformed URL"
+	}
+
+	// external
+	switch u.Scheme {
+	case "":
+		if len(u.Host) == 0 {
+			break
+		}
+		fallthrough
+	case "http", "https":
+		if len(oB.Config.Links.External) == 0 {
+			return ""
+		}
+		for _, pfx := range oB.Config.Links.External {
+			if strings.HasPrefix(raw, pfx) {
+				return ""
+			}
+		}
+		return "external link not in allow list"
+	default:
+		// mailto:, tel:, data:, etc.
+		return ""
+	}
+
+	// target page, relative to output root
+	tgt := page
+	if len(u.Path) > 0 {
+		if strings.HasPrefix(u.Path, "/") {
+			tgt = path.Clean(strings.TrimPrefix(u.Path, "/"))
+		} else {
+			tgt = path.Join(path.Dir(page), u.Path)
+		}
+		if strings.HasPrefix(tgt, "..") {
+			return "target outside of site"
+		}
+		// directories resolve to their index
+		if !oB.outputs[tgt] {
+			tgt = path.Join(tgt, "index.html")
+		}
+		if !oB.outputs[tgt] {
+			return "target not found"
+		}
+	}
+
+	if len(u.Fragment) == 0 {
+		return ""
+	}
+	pl, ok := mPages[tgt]
+	if !ok || pl.ids[u.Fragment] {
+		// not HTML, or found
+		return ""
+	}
+	return fmt.Sprintf("no element w/ id `%s` in %s", u.Fragment, tgt)
+}
+
+/*
+Locates text `needle` within source file `src`: the first line containing
+it, and its total number of occurrences.
+*/
+func (oB Builder) findInSource(src, needle string) (line int, text string, count int) {
+	bs, err := fs.ReadFile(oB.SrcFS, src)
+	if (err != nil) || (len(needle) == 0) {
+		return 0, "", 0
+	}
+	for ix, ln := range strings.Split(string(bs), "\n") {
+		n := strings.Count(ln, needle)
+		if (n > 0) && (count == 0) {
+			line, text = ix+1, strings.TrimRight(ln, "\r")
+		}
+		count += n
+	}
+	return
+}
+
+/*
+Locates a problem in output page `page`: in its document's source or its
+layout, at the line containing the first of sNeedle that occurs exactly once
+in both the page and those sources.  Otherwise (i.e. for generic needles
+like `<div>`), at `line` in the page itself.
+*/
+func (oB Builder) pageErr(page string, pageSrc []string, line int, sNeedle []string, msg string) error {
+
+	doc, ok := oB.pages[page]
+	if ok {
+		sSrc := []string{doc.TmplName}
+		if len(doc.LayoutName) > 0 {
+			sSrc = append(sSrc, CFGDIR+"/"+doc.LayoutName)
+		}
+		pageText := strings.Join(pageSrc, "\n")
+		for _, needle := range sNeedle {
+			if (len(needle) == 0) || (strings.Count(pageText, needle) != 1) {
+				continue
+			}
+			var te *TemplateError
+			nFound := 0
+			for _, src := range sSrc {
+				n, text, count := oB.findInSource(src, needle)
+				if (count > 0) && (te == nil) {
+					te = &TemplateError{File: src, Line: n, Msg: msg, Excerpt: excerpt(text, n, 0)}
+				}
+				nFound += count
+			}
+			if nFound == 1 {
+				return te
+			}
+		}
+	}
+
+	te := &TemplateError{File: path.Join(PUBDIR, page), Line: line, Msg: msg}
+	if ok && (len(doc.LayoutName) > 0) {
+		te.Msg += fmt.Sprintf(" (layout %s/%s)", CFGDIR, doc.LayoutName)
+	}
+	if (line > 0) && (line <= len(pageSrc)) {
+		te.Excerpt = excerpt(strings.TrimRight(pageSrc[line-1], "\r"), line, 0)
+	}
+	return te
+}
+
+/*
+Reads HTML pages written by the most recent Build(), by output path.
+Stale files left in the output by earlier builds are ignored.
+*/
+func (oB Builder) htmlPages() (map[string][]byte, []string, error) {
+
+	pub := oB.Output()
+	switch {
+	case pub == nil:
+		return nil, nil, errors.New("readable output required (not an archive)")
+	case oB.outputs == nil:
+		return nil, nil, errors.New("no output (run Build() first)")
+	}
+
+	var sPages []string
+	for fpath := range oB.outputs {
+		ext := strings.ToLower(path.Ext(fpath))
+		if (ext == ".html") || (ext == ".htm") {
+			sPages = append(sPages, fpath)
+		}
+	}
+	sort.Strings(sPages)
+
+	mPages := make(map[string][]byte, len(sPages))
+	for _, fpath := range sPages {
+		bs, err := fs.ReadFile(pub, fpath)
+		if err != nil {
+			return nil, nil, err
+		}
+		mPages[fpath] = bs
+	}
+	return mPages, sPages, nil
+}
+
+/*
+Output event for a problem w/ output page `page`.
+*/
+func (oB Builder) pageEvent(kind EventKind, page string, err error) Event {
+	ev := Event{Kind: kind, Src: page, Dst: page, Err: err}
+	if doc, ok := oB.pages[page]; ok {
+		ev.Src, ev.DocType = doc.TmplName, DT_DOC
+	}
+	return ev
+}
+
+/*
+Checks links in HTML output of the most recent Build(): internal targets
+must have been written by it (stale files in the output don't count), and
+`#fragment`s must match an element ID in their target.  External links are
+checked against the `links.external` allow list, when given.  Each broken
+link is reported as an EVT_ERROR.
+*/
+func (oB Builder) CheckLinks() error {
+
+	mHtml, sPages, err := oB.htmlPages()
+	if err != nil {
+		return EWrap(err, "link check")
+	}
+	mPages := make(map[string]pageLinks, len(mHtml))
+	for _, page := range sPages {
+		if mPages[page], err = scanPage(bytes.NewReader(mHtml[page])); err != nil {
+			return EWrap(err, page)
+		}
+	}
+
+	nBroken := 0
+	for _, page := range sPages {
+		var pageSrc []string
+		for _, lnk := range mPages[page].links {
+			msg := oB.checkLink(mPages, page, lnk.url)
+			if len(msg) == 0 {
+				continue
+			}
+			nBroken++
+			if pageSrc == nil {
+				pageSrc = strings.Split(string(mHtml[page]), "\n")
+			}
+
+			// markdown & templates may re-encode URLs
+			sNeedle := []string{lnk.url, strings.ReplaceAll(lnk.url, "&", "&amp;")}
+			if s, err := url.PathUnescape(lnk.url); (err == nil) && (s != lnk.url) {
+				sNeedle = append(sNeedle, s)
+			}
+			msg = fmt.Sprintf("broken link `%s`: %s", lnk.url, msg)
+			oB.emit(oB.pageEvent(EVT_ERROR, page, oB.pageErr(page, pageSrc, lnk.line, sNeedle, msg)))
+		}
+	}
+
+	if nBroken > 0 {
+		return fmt.Errorf("%d broken link(s)", nBroken)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanPage(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		html   string
+		sId    []string
+		sLinks []pageLink
+	}{
+		{
+			"ids & anchors",
+			"<h2 id=\"intro\">Intro</h2>\n<a name=\"old\"></a>\n<p name=\"not-an-anchor\">",
+			[]string{"intro", "old"},
+			nil,
+		},
+		{
+			"link attrs, by line",
+			"<a href=\"/a.html\">a</a>\n<link rel=\"stylesheet\" href=\"s.css\">\n\n<script src=\"x.js\"></script>\n<div href=\"ignored\"></div>",
+			nil,
+			[]pageLink{{"/a.html", 1}, {"s.css", 2}, {"x.js", 4}},
+		},
+		{
+			"srcset",
+			"<img alt=\"\" src=\"a.png\"\n  srcset=\"a.20w.png 20w, a.50w.png 50w,a.100w.png\">",
+			nil,
+			[]pageLink{{"a.png", 1}, {"a.20w.png", 1}, {"a.50w.png", 1}, {"a.100w.png", 1}},
+		},
+		{
+			"video poster",
+			"<video src=\"v.mp4\" poster=\"p.jpg\"></video>",
+			nil,
+			[]pageLink{{"v.mp4", 1}, {"p.jpg", 1}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pl, err := scanPage(strings.NewReader(tc.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pl.ids) != len(tc.sId) {
+				t.Errorf("ids = %v, want %v", pl.ids, tc.sId)
+			}
+			for _, id := range tc.sId {
+				if !pl.ids[id] {
+					t.Errorf("missing id %q", id)
+				}
+			}
+			if !reflect.DeepEqual(pl.links, tc.sLinks) {
+				t.Errorf("links = %v, want %v", pl.links, tc.sLinks)
+			}
+		})
+	}
+}
+
+func TestCheckLink(t *testing.T) {
+
+	oB := Builder{
+		outputs: map[string]bool{
+			"index.html":      true,
+			"about.html":      true,
+			"docs/index.html": true,
+			"docs/intro.html": true,
+			"img/a.20w.png":   true,
+			"style.css":       true,
+		},
+	}
+	oB.Config.Links = LinkConf{
+		External: []string{"https://example.com/"},
+		Ignore:   []string{"/api/"},
+	}
+	mPages := map[string]pageLinks{
+		"about.html":      {ids: map[string]bool{"team": true}},
+		"docs/intro.html": {ids: map[string]bool{"getting-started": true}},
+	}
+
+	tests := []struct {
+		page string
+		url  string
+		want string // "" = ok
+	}{
+		{"index.html", "/about.html", ""},
+		{"index.html", "/missing.html", "target not found"},
+		{"docs/intro.html", "../about.html", ""},
+		{"docs/intro.html", "intro.html", ""},
+		{"docs/intro.html", "../../x.html", "target outside of site"},
+
+		// directories resolve to their index.html
+		{"index.html", "/", ""},
+		{"index.html", "/docs/", ""},
+		{"about.html", "docs", ""},
+		{"index.html", "/img/", "target not found"},
+
+		// fragments
+		{"index.html", "/about.html#team", ""},
+		{"index.html", "/about.html#nobody", "no element w/ id `nobody` in about.html"},
+		{"docs/intro.html", "#getting-started", ""},
+		{"docs/intro.html", "#missing", "no element w/ id `missing` in docs/intro.html"},
+		{"index.html", "/style.css#x", ""}, // not HTML
+
+		// srcset candidates arrive as separate URLs
+		{"index.html", "/img/a.20w.png", ""},
+		{"index.html", "/img/a.50w.png", "target not found"},
+
+		// external
+		{"index.html", "https://example.com/page", ""},
+		{"index.html", "//example.com/page", "external link not in allow list"},
+		{"index.html", "https://elsewhere.org/", "external link not in allow list"},
+		{"index.html", "http://example.com/page", "external link not in allow list"},
+		{"index.html", "mailto:a@example.com", ""},
+		{"index.html", "/api/v1/users", ""},
+		{"index.html", "%zz", "malformed URL"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.page+" -> "+tc.url, func(t *testing.T) {
+			if got := oB.checkLink(mPages, tc.page, tc.url); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// w/o an allow list, external links are unchecked
+	oB.Config.Links.External = nil
+	if got := oB.checkLink(mPages, "index.html", "https://elsewhere.org/"); got != "" {
+		t.Errorf("unchecked external link: got %q", got)
+	}
+}
@@ -0,0 +1,169 @@
+package build
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	_ "golang.org/x/image/webp"
+)
+
+/*
+Dimensions of local image `dest` (0, 0 when unknown, i.e. remote or SVG).
+Paths are relative to the referencing document, or to the site root when
+they start with `/`.  Warns when a local image doesn't exist.
+*/
+func (cc *convCtx) imgSize(dest string) (w, h int) {
+
+	if cc == nil {
+		return
+	}
+	u, err := url.Parse(dest)
+	if (err != nil) || (len(u.Scheme) > 0) || (len(u.Host) > 0) || (len(u.Path) == 0) {
+		return
+	}
+
+	rel := u.Path
+	if strings.HasPrefix(rel, "/") {
+		rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	} else {
+		rel = path.Join(path.Dir(cc.src), rel)
+	}
+
+	pf, err := cc.fsys.Open(rel)
+	if err != nil {
+		cc.warn(fmt.Errorf("image `%s` not found", dest))
+		return
+	}
+	defer pf.Close()
+
+	cfg, _, err := image.DecodeConfig(pf)
+	if err != nil {
+		return
+	}
+	return cfg.Width, cfg.Height
+}
+
+// An image with a title, alone in its paragraph.
+var kindMdFigure = ast.NewNodeKind("Figure")
+
+type mdFigure struct {
+	ast.BaseBlock
+}
+
+func (n *mdFigure) Kind() ast.NodeKind {
+	return kindMdFigure
+}
+
+func (n *mdFigure) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+/*
+Replaces paragraphs holding only a titled image with figures.
+*/
+type mdFigureTransformer struct{}
+
+func (mdFigureTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+
+	var sPara []*ast.Paragraph
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		para, ok := n.(*ast.Paragraph)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		if img, ok := para.FirstChild().(*ast.Image); ok && (para.ChildCount() == 1) && (img.Title != nil) {
+			sPara = append(sPara, para)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+
+	for _, para := range sPara {
+		img := para.FirstChild()
+		para.RemoveChild(para, img)
+		fig := &mdFigure{}
+		fig.AppendChild(fig, img)
+		para.Parent().ReplaceChild(para.Parent(), para, fig)
+	}
+}
+
+/*
+Renders images with dimensions (when local), lazy loading, and async
+decoding.  Titled images alone in a paragraph become figures, with their
+title as the caption.
+*/
+type mdImageRenderer struct {
+	html.Config
+	cc *convCtx
+}
+
+func (r *mdImageRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindImage, r.renderImage)
+	reg.Register(kindMdFigure, r.renderFigure)
+}
+
+func (r *mdImageRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+	w.WriteString(`<img src="`)
+	if r.Unsafe || !html.IsDangerousURL(n.Destination) {
+		w.Write(util.EscapeHTML(util.URLEscape(n.Destination, true)))
+	}
+	w.WriteString(`" alt="`)
+	w.Write(util.EscapeHTML(n.Text(source)))
+	w.WriteByte('"')
+
+	// figure captions replace titles
+	bInFigure := (n.Parent() != nil) && (n.Parent().Kind() == kindMdFigure)
+	if (n.Title != nil) && !bInFigure {
+		w.WriteString(` title="`)
+		r.Writer.Write(w, n.Title)
+		w.WriteByte('"')
+	}
+
+	if wd, ht := r.cc.imgSize(string(n.Destination)); (wd > 0) && (ht > 0) {
+		w.WriteString(` width="` + strconv.Itoa(wd) + `" height="` + strconv.Itoa(ht) + `"`)
+	}
+	w.WriteString(` loading="lazy" decoding="async"`)
+
+	if n.Attributes() != nil {
+		html.RenderAttributes(w, n, html.ImageAttributeFilter)
+	}
+	if r.XHTML {
+		w.WriteString(" />")
+	} else {
+		w.WriteString(">")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mdImageRenderer) renderFigure(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+
+	if entering {
+		w.WriteString("<figure>\n")
+		return ast.WalkContinue, nil
+	}
+	if img, ok := node.FirstChild().(*ast.Image); ok {
+		w.WriteString("\n<figcaption>")
+		r.Writer.Write(w, img.Title)
+		w.WriteString("</figcaption>")
+	}
+	w.WriteString("\n</figure>\n")
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,104 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/BourgeoisBear/webjot/vars"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/json"
+	"github.com/tdewolff/minify/v2/svg"
+	"github.com/tdewolff/minify/v2/xml"
+)
+
+/*
+Minifiable output types, by site config name.
+*/
+var minifyTypes = map[string]struct {
+	Mime string
+	Exts []string
+	Fn   minify.Minifier
+}{
+	"html": {"text/html", []string{".html", ".htm"}, &html.Minifier{
+		KeepDocumentTags: true,
+		KeepEndTags:      true,
+		KeepQuotes:       true,
+	}},
+	"css":  {"text/css", []string{".css"}, &css.Minifier{}},
+	"js":   {"application/javascript", []string{".js", ".mjs"}, &js.Minifier{}},
+	"json": {"application/json", []string{".json"}, &json.Minifier{}},
+	"xml":  {"text/xml", []string{".xml", ".rss", ".atom"}, &xml.Minifier{}},
+	"svg":  {"image/svg+xml", []string{".svg"}, &svg.Minifier{}},
+}
+
+/*
+Minifies outputs by extension.
+*/
+type outMinifier struct {
+	m    *minify.M
+	mExt map[string]string // output extension -> media type
+}
+
+/*
+Creates a minifier for config types `sTypes` (i.e. [html, css]).
+Returns nil when sTypes is empty.
+*/
+func newOutMinifier(sTypes []string) (*outMinifier, error) {
+
+	if len(sTypes) == 0 {
+		return nil, nil
+	}
+
+	ret := &outMinifier{m: minify.New(), mExt: make(map[string]string)}
+	for _, name := range sTypes {
+		mt, ok := minifyTypes[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown minify type `%s`", name)
+		}
+		ret.m.Add(mt.Mime, mt.Fn)
+		for _, ext := range mt.Exts {
+			ret.mExt[ext] = mt.Mime
+		}
+	}
+	return ret, nil
+}
+
+/*
+Media type to minify output `dstrel` as, or "" to leave it as-is.
+Minification is skipped in watch mode, and for documents w/ `minify: false`.
+*/
+func (oB Builder) minifyMime(dstrel string, mV vars.Vars) string {
+	if oB.IsWatchMode || (oB.minifier == nil) {
+		return ""
+	}
+	if bMin, ok := mV["minify"].(bool); ok && !bMin {
+		return ""
+	}
+	return oB.minifier.mExt[strings.ToLower(path.Ext(dstrel))]
+}
+
+func (oB Builder) minifyBytes(mime string, data []byte) ([]byte, error) {
+	if len(mime) == 0 {
+		return data, nil
+	}
+	bs, err := oB.minifier.m.Bytes(mime, data)
+	if err != nil {
+		return nil, EWrap(err, "minify")
+	}
+	return bs, nil
+}
+
+/*
+Wraps w with a streaming minifier.  Close() flushes, but doesn't close w.
+*/
+func (oB Builder) minifyWriter(mime string, w io.Writer) io.WriteCloser {
+	if len(mime) == 0 {
+		return nopWriteCloser{w}
+	}
+	return oB.minifier.m.Writer(mime, w)
+}
@@ -0,0 +1,116 @@
+package build_test
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+/*
+Enabling minification for a site w/ existing output re-writes unchanged
+static files, and `sri` digests what's on disk.
+*/
+func TestMinifyExistingOutput(t *testing.T) {
+
+	root := t.TempDir()
+	fnWrite := func(rel, data string) {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fnBuild := func() {
+		pB, err := build.New(build.Options{SrcDir: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pB.Build(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fnRead := func(rel string) string {
+		bs, err := os.ReadFile(filepath.Join(root, build.PUBDIR, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	fnWrite(".webjot/layout.html", `{{ sri "app.js" }}`)
+	fnWrite("index.html", "x")
+	fnWrite("app.js", "// comment\nvar   answer = 42;\n")
+	fnBuild()
+	if js := fnRead("app.js"); !strings.Contains(js, "comment") {
+		t.Fatalf("unminified build: got %q", js)
+	}
+
+	fnWrite(".webjot/config.yaml", "minify: [js]\n")
+	fnBuild()
+	js := fnRead("app.js")
+	if strings.Contains(js, "comment") {
+		t.Errorf("minified build left app.js as-is: %q", js)
+	}
+	sum := sha512.Sum384([]byte(js))
+	if want := "sha384-" + base64.StdEncoding.EncodeToString(sum[:]); fnRead("index.html") != want {
+		t.Errorf("sri = %q, want digest of output %q", fnRead("index.html"), want)
+	}
+}
+
+/*
+Bundles with source maps skip minification, and list converted inputs
+under their output names.
+*/
+func TestMinifyBundles(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/config.yaml": {Data: []byte(
+			"minify: [css]\n" +
+				"bundles:\n" +
+				"  mapped.css: {files: [a.css, b.gcss], sourcemap: true}\n" +
+				"  plain.css: {files: [a.css, b.gcss]}\n",
+		)},
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"index.html":          {Data: []byte("x")},
+		"a.css":               {Data: []byte("p {  color : red ; }\n")},
+		"b.gcss":              {Data: []byte("h1\n  margin: 0\n")},
+	}
+
+	pub := buildtest.Build(t, build.Options{SrcFS: fsys})
+	fnRead := func(rel string) string {
+		bs, err := fs.ReadFile(pub, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	if got, want := fnRead("plain.css"), "p{color:red}h1{margin:0}"; got != want {
+		t.Errorf("plain.css: got %q, want %q", got, want)
+	}
+	want := "p {  color : red ; }\nh1{margin:0;}\n/*# sourceMappingURL=mapped.css.map */\n"
+	if got := fnRead("mapped.css"); got != want {
+		t.Errorf("mapped.css: got %q, want %q", got, want)
+	}
+
+	var sm struct {
+		Sources []string `json:"sources"`
+	}
+	if err := json.Unmarshal([]byte(fnRead("mapped.css.map")), &sm); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sm.Sources, " "); got != "/a.css /b.css" {
+		t.Errorf("sources: got %q, want %q", got, "/a.css /b.css")
+	}
+}
@@ -0,0 +1,159 @@
+package build
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	tt "text/template"
+)
+
+const (
+	CFGDIR        = ".webjot"
+	PUBDIR        = ".pub"
+	DEFAULT_DELIM = "@@@@@@@"
+)
+
+/*
+Settings for New().  Zero values select defaults.
+*/
+type Options struct {
+	SrcDir      string      // site root, or a directory inside it to build
+	SrcFS       fs.FS       // site sources, instead of SrcDir (root must contain CFGDIR)
+	PubDir      string      // output dir (default = `<site root>/.pub`)
+	Sink        Sink        // output destination (default = DirSink at PubDir)
+	HdrDelim    string      // vars/body delimiter (default = DEFAULT_DELIM)
+	DirMode     os.FileMode // default = 0755
+	FileMode    os.FileMode // default = 0644
+	IsWatchMode bool
+	IsStrict    bool // also enabled by `strict: true` in site config
+	IsKeepGoing bool // continue after file errors (always, in watch mode)
+	Cmd         CmdPolicy
+	Funcs       tt.FuncMap     // extra template funcs
+	Providers   []FuncProvider // extra per-document template funcs
+	OnEvent     EventFunc
+}
+
+/*
+Constructs a Builder for the site containing opt.SrcDir.
+The site root is the nearest ancestor of opt.SrcDir containing CFGDIR.
+Site configuration is read from `<CFGDIR>/config.yaml`.
+
+When opt.SrcFS is given, the whole of it is built instead.  Since those
+sources aren't on disk, watch mode & command caching are unavailable, and
+external commands run from the current directory.  Either opt.PubDir or
+opt.Sink must be given.
+*/
+func New(opt Options) (*Builder, error) {
+
+	oB := &Builder{
+		PubDir:      opt.PubDir,
+		DirMode:     opt.DirMode,
+		FileMode:    opt.FileMode,
+		IsWatchMode: opt.IsWatchMode,
+		IsKeepGoing: opt.IsKeepGoing || opt.IsWatchMode,
+		Cmd:         opt.Cmd,
+		Sink:        opt.Sink,
+		Formats:     DefaultFormats(),
+		stats:       new(BuildStats),
+		OnEvent:     opt.OnEvent,
+	}
+
+	for k, fn := range opt.Funcs {
+		if err := oB.RegisterFunc(k, fn); err != nil {
+			return nil, err
+		}
+	}
+	for _, fp := range opt.Providers {
+		if err := oB.RegisterFuncProvider(fp); err != nil {
+			return nil, err
+		}
+	}
+
+	if oB.DirMode == 0 {
+		oB.DirMode = 0755
+	}
+	if oB.FileMode == 0 {
+		oB.FileMode = 0644
+	}
+	if len(oB.Cmd.DirMode) == 0 {
+		oB.Cmd.DirMode = CMDDIR_DOC
+	}
+
+	hdrDelim := opt.HdrDelim
+	if len(hdrDelim) == 0 {
+		hdrDelim = DEFAULT_DELIM
+	}
+	if err := oB.SetHdrDelim(hdrDelim); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if opt.SrcFS != nil {
+
+		// whole FS is the site
+		if _, err = fs.Stat(opt.SrcFS, CFGDIR); err != nil {
+			return nil, EWrap(err, "site FS root")
+		}
+		if (len(oB.PubDir) == 0) && (oB.Sink == nil) {
+			return nil, errors.New("PubDir or Sink required for SrcFS builds")
+		}
+		oB.SrcFS = opt.SrcFS
+		oB.srcDir = "."
+
+	} else {
+
+		srcDir := opt.SrcDir
+		if len(srcDir) == 0 {
+			srcDir = "."
+		}
+		if srcDir, err = filepath.Abs(srcDir); err != nil {
+			return nil, err
+		}
+
+		// lookup conf dir parent
+		conf, err := SearchDirAncestors(srcDir, CFGDIR)
+		if err != nil {
+			return nil, err
+		}
+		oB.ConfDir = conf
+		oB.SrcRoot = filepath.Dir(conf)
+		oB.SrcFS = os.DirFS(oB.SrcRoot)
+		oB.Cmd.Cache.Dir = filepath.Join(oB.ConfDir, CACHEDIR)
+		if len(oB.PubDir) == 0 {
+			oB.PubDir = filepath.Join(oB.SrcRoot, PUBDIR)
+		}
+
+		// build dir, relative to site root
+		if oB.srcDir, err = filepath.Rel(oB.SrcRoot, srcDir); err != nil {
+			return nil, err
+		}
+		oB.srcDir = filepath.ToSlash(oB.srcDir)
+	}
+
+	if len(oB.PubDir) > 0 {
+		if oB.PubDir, err = filepath.Abs(oB.PubDir); err != nil {
+			return nil, err
+		}
+	}
+	if oB.Sink == nil {
+		oB.Sink = DirSink{Root: oB.PubDir, DirMode: oB.DirMode, FileMode: oB.FileMode}
+	}
+
+	// site config
+	if oB.Config, err = LoadSiteConfig(oB.SrcFS); err != nil {
+		return nil, err
+	}
+	if err = oB.Formats.Configure(oB.Config.Formats); err != nil {
+		return nil, err
+	}
+	oB.IsStrict = opt.IsStrict || oB.Config.Strict
+	if oB.minifier, err = newOutMinifier(oB.Config.Minify); err != nil {
+		return nil, EWrap(err, CFGDIR+"/"+CFGFILE)
+	}
+	if oB.Config.Bundles, err = cleanBundles(oB.Config.Bundles); err != nil {
+		return nil, EWrap(err, CFGDIR+"/"+CFGFILE)
+	}
+
+	return oB, nil
+}
@@ -0,0 +1,82 @@
+package build
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+Outputs written on demand: assets render when first referenced (through
+`asset` or `sri`), so references between them resolve in dependency order.
+Anything left is written afterwards, in path order.  A nil entry is in
+progress.
+*/
+type pendingMap map[string]func() error
+
+/*
+Writes pending output `dstrel` now.  No-op when not pending.
+*/
+func (oB Builder) flushPending(dstrel string) error {
+
+	fn, ok := oB.pending[dstrel]
+	if !ok {
+		return nil
+	}
+	if fn == nil {
+		return fmt.Errorf("asset cycle: `%s` references itself (through `asset` or `sri`)", dstrel)
+	}
+	oB.pending[dstrel] = nil
+	err := fn()
+	delete(oB.pending, dstrel)
+
+	// NOTE: reported by fn, so not repeated by referencing documents
+	if err != nil {
+		return fmt.Errorf("`%s` failed to build", dstrel)
+	}
+	return nil
+}
+
+/*
+Writes all pending outputs, in path order.
+Returns false when stopped by an error (see IsKeepGoing).
+*/
+func (oB Builder) flushAllPending() bool {
+
+	sDst := make([]string, 0, len(oB.pending))
+	for dst := range oB.pending {
+		sDst = append(sDst, dst)
+	}
+	sort.Strings(sDst)
+
+	for _, dst := range sDst {
+		if err := oB.flushPending(dst); (err != nil) && !oB.IsKeepGoing {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Writes output `rel` before it's referenced directly (through `asset` or
+`sri`).  Source images referenced directly are always copied.
+*/
+func (oB Builder) useOutput(rel string) error {
+	if (oB.imgRefs != nil) && oB.isSrcImage(rel) {
+		oB.imgRefs[rel] = false
+	}
+	return oB.flushPending(rel)
+}
+
+/*
+Source images, which imgResize may replace with variants.
+*/
+func (oB Builder) isSrcImage(srcpath string) bool {
+	switch strings.ToLower(path.Ext(srcpath)) {
+	case ".jpg", ".jpeg", ".png":
+		fmtDoc, ok := oB.Formats.Get(path.Ext(srcpath))
+		return !ok || !fmtDoc.IsProcessed()
+	}
+	return false
+}
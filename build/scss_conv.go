@@ -0,0 +1,35 @@
+package build
+
+import (
+	"errors"
+	"io"
+
+	"github.com/BourgeoisBear/webjot/scss"
+)
+
+/*
+Converts *scss.Error into *TemplateError, located in the original source.
+*/
+func scssErr(err error, doc Doc) error {
+	var se *scss.Error
+	if !errors.As(err, &se) {
+		return err
+	}
+	return docErr(&TemplateError{
+		File: se.File, Line: se.Line, Col: se.Col, Msg: se.Msg, Excerpt: se.Text,
+	}, doc)
+}
+
+/*
+Compiles template-expanded SCSS `src` of `doc` into CSS.
+*/
+func (cc *convCtx) compileScss(dst io.Writer, src []byte, doc Doc) error {
+	opt := scss.Options{Filename: doc.TmplName}
+	if cc != nil {
+		opt.Import = func(name, from string) (string, []byte, error) {
+			return cc.findImport(name, from, ".scss")
+		}
+		opt.Warn = func(err error) { cc.warn(scssErr(err, doc)) }
+	}
+	return scssErr(scss.Compile(dst, src, opt), doc)
+}
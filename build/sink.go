@@ -0,0 +1,377 @@
+package build
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Destination for built files.
+Paths are slash-separated, and relative to the output root.
+*/
+type Sink interface {
+	// Create/truncate `rel` for writing, creating parent dirs as needed.
+	Create(rel string) (io.WriteCloser, error)
+	// Write contents of a static source file to `rel`.
+	Copy(rel string, src io.Reader, info fs.FileInfo) error
+	// Flush & finalize output.
+	Close() error
+}
+
+/*
+Sinks that can be read back, i.e. for serving in watch mode.
+*/
+type FSSink interface {
+	Sink
+	FS() fs.FS
+}
+
+/*
+Sinks that can delete output, i.e. stale fingerprinted assets.
+*/
+type RemoverSink interface {
+	Sink
+	// Removes `rel`.  Missing files are not an error.
+	Remove(rel string) error
+}
+
+/*
+Writes output to directory Root, on disk.
+*/
+type DirSink struct {
+	Root     string
+	DirMode  os.FileMode
+	FileMode os.FileMode
+}
+
+func (ds DirSink) path(rel string) string {
+	return filepath.Join(ds.Root, filepath.FromSlash(rel))
+}
+
+func (ds DirSink) Create(rel string) (io.WriteCloser, error) {
+	dst := ds.path(rel)
+	if err := os.MkdirAll(filepath.Dir(dst), ds.DirMode); err != nil {
+		return nil, err
+	}
+	flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	return os.OpenFile(dst, flags, ds.FileMode)
+}
+
+// Copies only when dirty, see CopyOnDirty().
+func (ds DirSink) Copy(rel string, src io.Reader, info fs.FileInfo) error {
+	dst := ds.path(rel)
+	if err := os.MkdirAll(filepath.Dir(dst), ds.DirMode); err != nil {
+		return err
+	}
+	return CopyOnDirty(dst, src, info, ds.FileMode)
+}
+
+func (ds DirSink) Remove(rel string) error {
+	err := os.Remove(ds.path(rel))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (ds DirSink) Close() error {
+	return nil
+}
+
+func (ds DirSink) FS() fs.FS {
+	return os.DirFS(ds.Root)
+}
+
+/*
+Keeps output in memory.  Safe for concurrent reads (through FS()) during
+writes.
+*/
+type MemSink struct {
+	mu    sync.RWMutex
+	files map[string]memFile
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemSink() *MemSink {
+	return &MemSink{files: make(map[string]memFile)}
+}
+
+type memWriter struct {
+	bytes.Buffer
+	ms  *MemSink
+	rel string
+}
+
+func (mw *memWriter) Close() error {
+	mw.ms.put(mw.rel, mw.Bytes(), time.Now())
+	return nil
+}
+
+func (ms *MemSink) put(rel string, data []byte, modTime time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.files[path.Clean(rel)] = memFile{data: data, modTime: modTime}
+}
+
+func (ms *MemSink) Remove(rel string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.files, path.Clean(rel))
+	return nil
+}
+
+func (ms *MemSink) Create(rel string) (io.WriteCloser, error) {
+	return &memWriter{ms: ms, rel: rel}, nil
+}
+
+func (ms *MemSink) Copy(rel string, src io.Reader, info fs.FileInfo) error {
+	bs, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	ms.put(rel, bs, info.ModTime())
+	return nil
+}
+
+func (ms *MemSink) Close() error {
+	return nil
+}
+
+func (ms *MemSink) FS() fs.FS {
+	return ms
+}
+
+// Implements fs.FS.
+func (ms *MemSink) Open(name string) (fs.File, error) {
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if mf, ok := ms.files[name]; ok {
+		return &memFileHandle{
+			Reader: bytes.NewReader(mf.data),
+			info:   memInfo{name: path.Base(name), size: int64(len(mf.data)), modTime: mf.modTime},
+		}, nil
+	}
+
+	// directory: collect immediate children
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	mEnts := make(map[string]memInfo)
+	for k, mf := range ms.files {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		child, rest, bIsDir := strings.Cut(strings.TrimPrefix(k, prefix), "/")
+		if bIsDir {
+			mEnts[child] = memInfo{name: child, isDir: true}
+		} else if len(rest) == 0 {
+			mEnts[child] = memInfo{name: child, size: int64(len(mf.data)), modTime: mf.modTime}
+		}
+	}
+	if (len(mEnts) == 0) && (name != ".") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	ents := make([]fs.DirEntry, 0, len(mEnts))
+	for _, mi := range mEnts {
+		ents = append(ents, fs.FileInfoToDirEntry(mi))
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+
+	return &memDirHandle{
+		info: memInfo{name: path.Base(name), isDir: true},
+		ents: ents,
+	}, nil
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (mi memInfo) Name() string       { return mi.name }
+func (mi memInfo) Size() int64        { return mi.size }
+func (mi memInfo) ModTime() time.Time { return mi.modTime }
+func (mi memInfo) IsDir() bool        { return mi.isDir }
+func (mi memInfo) Sys() interface{}   { return nil }
+func (mi memInfo) Mode() fs.FileMode {
+	if mi.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type memFileHandle struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (mh *memFileHandle) Stat() (fs.FileInfo, error) { return mh.info, nil }
+func (mh *memFileHandle) Close() error               { return nil }
+
+type memDirHandle struct {
+	info memInfo
+	ents []fs.DirEntry
+	pos  int
+}
+
+func (md *memDirHandle) Stat() (fs.FileInfo, error) { return md.info, nil }
+func (md *memDirHandle) Close() error               { return nil }
+func (md *memDirHandle) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: md.info.name, Err: fs.ErrInvalid}
+}
+
+func (md *memDirHandle) ReadDir(n int) ([]fs.DirEntry, error) {
+	rem := md.ents[md.pos:]
+	if n <= 0 {
+		md.pos = len(md.ents)
+		return rem, nil
+	}
+	if len(rem) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rem) {
+		n = len(rem)
+	}
+	md.pos += n
+	return rem[:n], nil
+}
+
+/*
+Writes output into a zip archive.  Each path should be written only once.
+*/
+type ZipSink struct {
+	zw *zip.Writer
+}
+
+func NewZipSink(w io.Writer) *ZipSink {
+	return &ZipSink{zw: zip.NewWriter(w)}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type zipWriter struct {
+	bytes.Buffer
+	zs  *ZipSink
+	rel string
+}
+
+func (zw *zipWriter) Close() error {
+	w, err := zw.zs.create(zw.rel, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(zw.Bytes())
+	return err
+}
+
+func (zs *ZipSink) create(rel string, modTime time.Time) (io.Writer, error) {
+	return zs.zw.CreateHeader(&zip.FileHeader{
+		Name:     path.Clean(rel),
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+}
+
+/*
+NOTE: a zip entry is closed as soon as the next one starts, and outputs may be
+written while a page renders (e.g. by imgResize), so output is buffered until
+Close().
+*/
+func (zs *ZipSink) Create(rel string) (io.WriteCloser, error) {
+	return &zipWriter{zs: zs, rel: rel}, nil
+}
+
+func (zs *ZipSink) Copy(rel string, src io.Reader, info fs.FileInfo) error {
+	w, err := zs.create(rel, info.ModTime())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+func (zs *ZipSink) Close() error {
+	return zs.zw.Close()
+}
+
+/*
+Writes output into a tar archive.  Each path should be written only once.
+*/
+type TarSink struct {
+	tw       *tar.Writer
+	fileMode os.FileMode
+}
+
+func NewTarSink(w io.Writer, fileMode os.FileMode) *TarSink {
+	return &TarSink{tw: tar.NewWriter(w), fileMode: fileMode}
+}
+
+type tarWriter struct {
+	bytes.Buffer
+	ts  *TarSink
+	rel string
+}
+
+func (tw *tarWriter) Close() error {
+	return tw.ts.write(tw.rel, tw.Bytes(), time.Now())
+}
+
+func (ts *TarSink) write(rel string, data []byte, modTime time.Time) error {
+	err := ts.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Clean(rel),
+		Size:     int64(len(data)),
+		Mode:     int64(ts.fileMode),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ts.tw.Write(data)
+	return err
+}
+
+// NOTE: tar headers need sizes up-front, so output is buffered until Close().
+func (ts *TarSink) Create(rel string) (io.WriteCloser, error) {
+	return &tarWriter{ts: ts, rel: rel}, nil
+}
+
+func (ts *TarSink) Copy(rel string, src io.Reader, info fs.FileInfo) error {
+	bs, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	return ts.write(rel, bs, info.ModTime())
+}
+
+func (ts *TarSink) Close() error {
+	return ts.tw.Close()
+}
@@ -0,0 +1,107 @@
+package build_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+)
+
+/*
+Fixture sites built into each Sink read back the same as DirSink output.
+*/
+func TestSinkRoundTrip(t *testing.T) {
+
+	fnBuild := func(t *testing.T, siteDir string, snk build.Sink) {
+		pB, err := build.New(build.Options{
+			SrcDir: siteDir,
+			Sink:   snk,
+			OnEvent: func(ev build.Event) {
+				if ev.Kind == build.EVT_ERROR {
+					t.Errorf("[%s] %v", ev.Src, ev.Err)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pB.Build(); err != nil {
+			t.Fatal(err)
+		}
+		if err = snk.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fnZip := func(bs []byte) fs.FS {
+		zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return zr
+	}
+
+	fnTar := func(bs []byte) fs.FS {
+		ret := make(fstest.MapFS)
+		tr := tar.NewReader(bytes.NewReader(bs))
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := ret[hdr.Name]; ok {
+				t.Errorf("tar: %s written twice", hdr.Name)
+			}
+			ret[hdr.Name] = &fstest.MapFile{Data: data, Mode: fs.FileMode(hdr.Mode)}
+		}
+		return ret
+	}
+
+	for _, site := range []string{"layouts", "fingerprint", "mdimages", "bundles"} {
+		t.Run(site, func(t *testing.T) {
+
+			siteDir := filepath.Join("testdata", "sites", site)
+			pubDir := t.TempDir()
+			fnBuild(t, siteDir, build.DirSink{Root: pubDir, DirMode: 0755, FileMode: 0644})
+			want := os.DirFS(pubDir)
+			if _, err := fs.Stat(want, "index.html"); err != nil {
+				t.Fatal(err)
+			}
+
+			ms := build.NewMemSink()
+			fnBuild(t, siteDir, ms)
+
+			var zipBuf, tarBuf bytes.Buffer
+			fnBuild(t, siteDir, build.NewZipSink(&zipBuf))
+			fnBuild(t, siteDir, build.NewTarSink(&tarBuf, 0644))
+
+			for name, got := range map[string]fs.FS{
+				"mem": ms.FS(),
+				"zip": fnZip(zipBuf.Bytes()),
+				"tar": fnTar(tarBuf.Bytes()),
+			} {
+				diffs, err := build.DiffFS(got, want)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				for _, d := range diffs {
+					t.Errorf("%s: %v", name, d)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+/*
+Reads a tar stream into an in-memory fs.FS.
+Only regular files are kept.
+*/
+func TarFS(r io.Reader) (*MemSink, error) {
+
+	ms := NewMemSink()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return ms, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		bs, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		ms.put(strings.TrimPrefix(hdr.Name, "./"), bs, hdr.ModTime)
+	}
+}
+
+/*
+Sources for the site rooted at `dir`, as of git revision `rev`
+(any commit-ish, e.g. a tag, branch, or hash).
+`dir` must be inside a git work tree.
+*/
+func GitFS(dir, rev string) (*MemSink, error) {
+
+	fnGit := func(cwd string, args ...string) ([]byte, error) {
+		var bufErr bytes.Buffer
+		pCmd := exec.Command("git", append([]string{"-C", cwd}, args...)...)
+		pCmd.Stderr = &bufErr
+		bs, err := pCmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(bufErr.String()); len(msg) > 0 {
+				err = errors.New(msg)
+			}
+			return nil, EWrap(err, fmt.Sprintf("git %s", args[0]))
+		}
+		return bs, nil
+	}
+
+	// site root, relative to repo root
+	bs, err := fnGit(dir, "rev-parse", "--show-toplevel", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	sLines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	top := strings.TrimSpace(sLines[0])
+	prefix := ""
+	if len(sLines) > 1 {
+		prefix = strings.TrimSuffix(strings.TrimSpace(sLines[1]), "/")
+	}
+
+	// archive only the site's subtree
+	treeish := rev
+	if len(prefix) > 0 {
+		treeish = rev + ":" + prefix
+	}
+	if bs, err = fnGit(top, "archive", "--format=tar", treeish); err != nil {
+		return nil, err
+	}
+	return TarFS(bytes.NewReader(bs))
+}
@@ -0,0 +1,187 @@
+package build_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+/*
+A site in a repo subdirectory builds as of an earlier revision.
+*/
+func TestGitFS(t *testing.T) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	fnGit := func(args ...string) {
+		t.Helper()
+		pCmd := exec.Command("git", append([]string{
+			"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com",
+			"-c", "commit.gpgsign=false",
+		}, args...)...)
+		if bs, err := pCmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, bs)
+		}
+	}
+	fnWrite := func(rel, data string) {
+		t.Helper()
+		fpath := filepath.Join(repo, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fnGit("init", "-q")
+	fnWrite("outside.html", "not in site")
+	fnWrite("docs/site/.webjot/layout.html", "<main>{{ doTmpl .DOC_KEY . }}</main>")
+	fnWrite("docs/site/index.html", "v1")
+	fnWrite("docs/site/sub/page.md", "# Page")
+	fnGit("add", "-A")
+	fnGit("commit", "-q", "-m", "v1")
+	fnGit("tag", "v1")
+	fnWrite("docs/site/index.html", "v2")
+	fnWrite("docs/site/new.html", "new")
+	fnGit("add", "-A")
+	fnGit("commit", "-q", "-m", "v2")
+
+	// uncommitted changes aren't built
+	fnWrite("docs/site/index.html", "v3")
+
+	tests := []struct {
+		rev   string
+		mWant map[string]string
+	}{
+		{"v1", map[string]string{
+			"index.html":    "<main>v1</main>",
+			"sub/page.html": "<main><h1 id=\"page\">Page</h1>\n</main>",
+		}},
+		{"HEAD", map[string]string{
+			"index.html":    "<main>v2</main>",
+			"sub/page.html": "<main><h1 id=\"page\">Page</h1>\n</main>",
+			"new.html":      "<main>new</main>",
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.rev, func(t *testing.T) {
+			fsys, err := build.GitFS(filepath.Join(repo, "docs", "site"), tc.rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub := buildtest.Build(t, build.Options{SrcFS: fsys.FS()})
+			mGot := readFiles(t, pub)
+			for rel, want := range tc.mWant {
+				if mGot[rel] != want {
+					t.Errorf("%s: got %q, want %q", rel, mGot[rel], want)
+				}
+			}
+			if len(mGot) != len(tc.mWant) {
+				t.Errorf("got files %v, want %v", mGot, tc.mWant)
+			}
+		})
+	}
+
+	if _, err := build.GitFS(filepath.Join(repo, "docs", "site"), "nope"); err == nil {
+		t.Error("unknown revision: got no error")
+	}
+}
+
+/*
+Tar streams read the same with or without directory entries (and `./`
+prefixes).
+*/
+func TestTarFS(t *testing.T) {
+
+	mSrc := map[string]string{
+		".webjot/layout.html": "<main>{{ doTmpl .DOC_KEY . }}</main>",
+		"index.html":          "home",
+		"a/b/page.html":       "page",
+		"a/plain.txt":         "plain",
+	}
+
+	fnTar := func(bDirs bool, prefix string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		fnHdr := func(hdr *tar.Header) {
+			hdr.ModTime = time.Unix(0, 0)
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if bDirs {
+			for _, dir := range []string{".webjot/", "a/", "a/b/"} {
+				fnHdr(&tar.Header{Typeflag: tar.TypeDir, Name: prefix + dir, Mode: 0755})
+			}
+		}
+		for rel, data := range mSrc {
+			fnHdr(&tar.Header{Typeflag: tar.TypeReg, Name: prefix + rel, Mode: 0644, Size: int64(len(data))})
+			if _, err := tw.Write([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	mWant := map[string]string{
+		"index.html":    "<main>home</main>",
+		"a/b/page.html": "<main>page</main>",
+		"a/plain.txt":   "plain",
+	}
+
+	for name, bs := range map[string][]byte{
+		"files only": fnTar(false, ""),
+		"dirs":       fnTar(true, ""),
+		"dot prefix": fnTar(true, "./"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys, err := build.TarFS(bytes.NewReader(bs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mGot := readFiles(t, buildtest.Build(t, build.Options{SrcFS: fsys.FS()}))
+			if len(mGot) != len(mWant) {
+				t.Errorf("got files %v, want %v", mGot, mWant)
+			}
+			for rel, want := range mWant {
+				if mGot[rel] != want {
+					t.Errorf("%s: got %q, want %q", rel, mGot[rel], want)
+				}
+			}
+		})
+	}
+}
+
+// regular files in fsys, by path
+func readFiles(tb testing.TB, fsys fs.FS) map[string]string {
+	tb.Helper()
+	ret := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(fpath string, de fs.DirEntry, err error) error {
+		if (err != nil) || de.IsDir() {
+			return err
+		}
+		bs, err := fs.ReadFile(fsys, fpath)
+		ret[fpath] = string(bs)
+		return err
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ret
+}
@@ -0,0 +1,56 @@
+package build
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+func sriDigest(data []byte) string {
+	sum := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+/*
+Documents whose output others may reference (fingerprinted, or not
+layoutable, i.e. CSS), and so render first.
+*/
+func (oB Builder) isAsset(doc Doc) bool {
+	if oB.isFingerprinted(doc.DstPath) {
+		return true
+	}
+	return !oB.Formats.IsLayoutableExt(path.Ext(doc.TmplName))
+}
+
+/*
+Subresource Integrity digest (`sha384-...`) of output file `rel`, for the
+`sri` template func.  Rendered assets are digested as they're written;
+static files are digested from source on first use.
+*/
+func (oB Builder) assetSRI(rel string) (string, error) {
+
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	if err := oB.useOutput(rel); err != nil {
+		return "", err
+	}
+	if d, ok := oB.digests[rel]; ok {
+		return d, nil
+	}
+
+	// static files are copied verbatim (hidden files aren't)
+	bHidden := strings.HasPrefix(rel, ".") || strings.Contains(rel, "/.")
+	if fmtDoc, ok := oB.Formats.Get(path.Ext(rel)); !bHidden && (!ok || !fmtDoc.IsProcessed()) {
+		bs, err := fs.ReadFile(oB.SrcFS, rel)
+		if err == nil {
+			d := sriDigest(bs)
+			if oB.digests != nil {
+				oB.digests[rel] = d
+			}
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("asset `%s` not found (or not yet built)", rel)
+}
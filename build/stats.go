@@ -0,0 +1,55 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned by Build() when any file failed (details are sent to OnEvent).
+var ErrBuildFailed = errors.New("build failed")
+
+/*
+Tallies for the most recent Build().
+*/
+type BuildStats struct {
+	Rendered int // documents rendered
+	Copied   int // static files copied
+	Skipped  int // documents w/ `skip: true`
+	Warnings int
+	Errors   int
+	Elapsed  time.Duration
+}
+
+func (bs *BuildStats) count(ek EventKind) {
+	switch ek {
+	case EVT_RENDER:
+		bs.Rendered++
+	case EVT_COPY:
+		bs.Copied++
+	case EVT_SKIP:
+		bs.Skipped++
+	case EVT_WARN:
+		bs.Warnings++
+	case EVT_ERROR:
+		bs.Errors++
+	}
+}
+
+func (bs BuildStats) String() string {
+	return fmt.Sprintf(
+		"%d rendered, %d copied, %d skipped, %d warning(s), %d error(s) in %v",
+		bs.Rendered, bs.Copied, bs.Skipped, bs.Warnings, bs.Errors,
+		bs.Elapsed.Round(time.Millisecond),
+	)
+}
+
+/*
+Tallies for the most recent Build().
+*/
+func (oB Builder) Stats() BuildStats {
+	if oB.stats == nil {
+		return BuildStats{}
+	}
+	return *oB.stats
+}
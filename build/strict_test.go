@@ -0,0 +1,96 @@
+package build_test
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+)
+
+func TestStrictMode(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		src    string
+		line   int
+		sWant  []string // in error message
+		bLoose bool     // passes when not strict
+	}{
+		{
+			name:   "missing var",
+			src:    "title: Hi\n@@@@@@@\n<h1>{{ .title }}</h1>\n<p>{{ .titel }}</p>\n",
+			line:   4,
+			sWant:  []string{"titel"},
+			bLoose: true,
+		},
+		{
+			name:   "non-conforming key",
+			src:    "title: Hi\nSub-Title: x\n@@@@@@@\n<h1>{{ .title }}</h1>\n",
+			line:   2,
+			sWant:  []string{"non-conforming header keys", "Sub-Title"},
+			bLoose: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			fsys := fstest.MapFS{
+				".webjot/layout.html": {Data: []byte("{{ doTmpl .DOC_KEY . }}")},
+				"page.html":           {Data: []byte(tc.src)},
+			}
+
+			_, sEvt := buildtest.BuildEvents(t, build.Options{SrcFS: fsys, IsStrict: true})
+			if len(sEvt) != 1 {
+				t.Fatalf("got %d events, want 1: %v", len(sEvt), sEvt)
+			}
+			ev := sEvt[0]
+			if (ev.Kind != build.EVT_ERROR) || (ev.Src != "page.html") {
+				t.Fatalf("got %v event for %s, want error for page.html", ev.Kind, ev.Src)
+			}
+			var te *build.TemplateError
+			if !errors.As(ev.Err, &te) {
+				t.Fatalf("got %T (%v), want *build.TemplateError", ev.Err, ev.Err)
+			}
+			if (te.File != "page.html") || (te.Line != tc.line) {
+				t.Errorf("located at %s:%d, want page.html:%d", te.File, te.Line, tc.line)
+			}
+			for _, s := range tc.sWant {
+				if !strings.Contains(te.Msg, s) {
+					t.Errorf("message %q lacks %q", te.Msg, s)
+				}
+			}
+
+			if tc.bLoose {
+				buildtest.Build(t, build.Options{SrcFS: fsys})
+			}
+		})
+	}
+}
+
+/*
+`.WATCHMODE` is defined for strict templates, but commands only see
+`$ZS_WATCHMODE` in watch mode.
+*/
+func TestStrictWatchMode(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte("{{ doTmpl .DOC_KEY . }}")},
+		"page.html": {Data: []byte(
+			`[{{ .WATCHMODE }}][{{ doCmd "sh" "-c" "echo -n ${ZS_WATCHMODE+set}" }}]`,
+		)},
+	}
+
+	pub := buildtest.Build(t, build.Options{SrcFS: fsys, IsStrict: true})
+	bs, err := fs.ReadFile(pub, "page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(bs); got != "[][]" {
+		t.Errorf("got %q, want %q", got, "[][]")
+	}
+}
@@ -0,0 +1,404 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	tt "text/template"
+	"time"
+	"unicode"
+
+	"github.com/BourgeoisBear/webjot/vars"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v3"
+)
+
+func HasUcase(s string) bool {
+	for _, c := range s {
+		if unicode.IsUpper(c) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Renders doc's template into iDst, piping the result through the document's
+external `filters` (if any), then through format conversion (if any).
+*/
+func (oB Builder) postProcess(
+	iDst io.Writer,
+	doc Doc,
+	data interface{},
+	fnWarn func(error),
+) error {
+	filters, err := doc.Vars.GetFilters()
+	if err != nil {
+		return err
+	}
+	fmtDoc, _ := oB.Formats.Get(filepath.Ext(doc.TmplName))
+	if fmtDoc.IsTemplate && (len(filters) == 0) && !fmtDoc.HasConversion() {
+		return doc.Tmpl.Execute(iDst, data)
+	}
+
+	// pre-render template
+	bs := doc.Source
+	if fmtDoc.IsTemplate {
+		buf := bytes.NewBuffer(make([]byte, 0, 64*1024))
+		if err := doc.Tmpl.Execute(buf, data); err != nil {
+			return err
+		}
+		bs = buf.Bytes()
+	}
+
+	// external filters
+	for _, fcmd := range filters {
+		if bs, err = oB.runFilter(doc, fcmd, bs, fnWarn); err != nil {
+			return err
+		}
+	}
+
+	// format conversion
+	switch {
+	case fmtDoc.Convert != nil:
+		doc.conv = oB.convCtxFor(doc.TmplName, fnWarn)
+		return fmtDoc.Convert(iDst, bs, doc)
+	case len(fmtDoc.Command) > 0:
+		if bs, err = oB.runFilter(doc, fmtDoc.Command, bs, fnWarn); err != nil {
+			return err
+		}
+	}
+	_, err = iDst.Write(bs)
+	return err
+}
+
+type DocsMap map[string]Doc
+
+func (oB Builder) funcMap(
+	tmplName string,
+	mDocs DocsMap,
+	sNavDocs []vars.Vars,
+) map[string]interface{} {
+
+	fnVars := func(name string) (Doc, bool) {
+		doc, ok := mDocs[name]
+		return doc, ok
+	}
+
+	fnWarn := func(err error) {
+		oB.emit(Event{Kind: EVT_WARN, Src: tmplName, Err: err})
+	}
+
+	// command options for the current doc
+	chain := newTmplChain()
+	fnOpts := func() (Doc, CmdOpts) {
+		doc, _ := fnVars(tmplName)
+		co := oB.Cmd.OptsFor(doc, oB.SrcRoot)
+		co.chain = oB.chainEnv(chain, tmplName)
+		return doc, co
+	}
+
+	var funcmap map[string]interface{}
+	funcmap = map[string]interface{}{
+		"md2html": func(md string) (string, error) {
+			var bufHtml bytes.Buffer
+			err := md2html(&bufHtml, []byte(md), oB.convCtxFor(tmplName, fnWarn))
+			return bufHtml.String(), err
+		},
+		"doCmd": func(cmd string, params ...string) (string, error) {
+			doc, co := fnOpts()
+			return runCmdOutput(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		"doCmdOut": func(cmd string, params ...string) (string, error) {
+			doc, co := fnOpts()
+			co.IsStdoutOnly = true
+			return runCmdOutput(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		"doCmdCached": func(cmd string, params ...string) (string, error) {
+			doc, co := fnOpts()
+			co.IsCached = true
+			return runCmdOutput(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		"doCmdWith": func(opts interface{}, cmd string, params ...string) (interface{}, error) {
+			doc, co := fnOpts()
+			mOpts, err := CmdOptsMap(opts)
+			if err != nil {
+				return "", err
+			}
+			if err = co.Apply(mOpts, doc, oB.SrcRoot); err != nil {
+				return "", err
+			}
+			if len(co.Parse) > 0 {
+				return runCmdData(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+			}
+			return runCmdOutput(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		"doCmdJSON": func(cmd string, params ...string) (interface{}, error) {
+			doc, co := fnOpts()
+			co.Parse = CMDPARSE_JSON
+			return runCmdData(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		"doCmdYAML": func(cmd string, params ...string) (interface{}, error) {
+			doc, co := fnOpts()
+			co.Parse = CMDPARSE_YAML
+			return runCmdData(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		"doCmdPipe": func(stdin string, cmd string, params ...string) (string, error) {
+			doc, co := fnOpts()
+			co.Stdin = stdin
+			co.IsStdoutOnly = true
+			return runCmdOutput(oB.Cmd.Cache, co, doc.Vars, fnWarn, cmd, params...)
+		},
+		// raw document body (below header), before template expansion
+		"docSource": func(name string) (string, error) {
+			doc, ok := fnVars(name)
+			if !ok {
+				return "", fmt.Errorf("document `%s` not found", name)
+			}
+			return string(doc.Source), nil
+		},
+		// NOTE: tmplName == document src path, relative to document root
+		"doTmpl": func(tmplName string, data interface{}) (string, error) {
+			// get doc
+			doc, ok := fnVars(tmplName)
+			if !ok {
+				return "", fmt.Errorf("template `%s` not found", tmplName)
+			}
+			// default to doc's own vars when data == nil
+			if data == nil {
+				data = doc.Vars
+			}
+			if err := oB.pushChain(chain, tmplName, data); err != nil {
+				return "", err
+			}
+			defer chain.pop()
+			// render
+			pbuf := bytes.NewBuffer(make([]byte, 0, 64*1024))
+			if doc.Tmpl != nil {
+				doc.Tmpl.Funcs(funcmap)
+			}
+			err := oB.postProcess(pbuf, doc, data, fnWarn)
+			return pbuf.String(), oB.templateErr(err)
+		},
+		// URL of output file, fingerprinted when enabled
+		"asset": func(rel string) (string, error) {
+			return oB.assetURL(rel)
+		},
+		// SRI digest of output file
+		"sri": func(rel string) (string, error) {
+			return oB.assetSRI(rel)
+		},
+		// URL of bundle, fingerprinted when enabled
+		"bundle": func(name string) (string, error) {
+			return oB.bundleURL(name)
+		},
+		// resized image variant
+		"imgResize": func(rel string, width int) (Image, error) {
+			return oB.imgResize(rel, width)
+		},
+		// resized image variants, for `srcset`
+		"imgSrcset": func(rel string, widths ...int) (ImageSet, error) {
+			return oB.imgSrcset(rel, widths...)
+		},
+		"docsAll": func() []vars.Vars {
+			// clone
+			ret := make([]vars.Vars, len(sNavDocs))
+			for i := range sNavDocs {
+				ret[i] = sNavDocs[i]
+			}
+			return ret
+		},
+		"docsSort": func(sVars []vars.Vars, bAsc bool, ordKeys ...string) []vars.Vars {
+			if len(ordKeys) == 0 {
+				return sVars
+			}
+			sort.Slice(sVars, func(i, j int) bool {
+				sT := make([]string, 2)
+				for ixStr, ixVar := range []int{i, j} {
+					for _, ordK := range ordKeys {
+						if v, ok := sVars[ixVar][ordK].(string); ok {
+							sT[ixStr] = v
+							break
+						}
+					}
+				}
+				if bAsc {
+					return sT[0] < sT[1]
+				}
+				return sT[0] > sT[1]
+			})
+			return sVars
+		},
+		"docsGroup": func(sVars []vars.Vars, key, sep string) map[string][]vars.Vars {
+			ret := make(map[string][]vars.Vars)
+			for _, v := range sVars {
+				gval, ok := v[key].(string)
+				if !ok || len(gval) == 0 {
+					continue
+				}
+				for _, grp := range strings.Split(gval, sep) {
+					grp = strings.TrimSpace(grp)
+					ret[grp] = append(ret[grp], v)
+				}
+			}
+			return ret
+		},
+		"toSlice": func(vals ...interface{}) []interface{} {
+			ret := make([]interface{}, len(vals))
+			for i := range vals {
+				ret[i] = vals[i]
+			}
+			return ret
+		},
+		"toMap": func(vals ...interface{}) map[interface{}]interface{} {
+			ret := make(map[interface{}]interface{}, len(vals)/2)
+			for ix := range vals {
+				if (ix % 2) == 1 {
+					ret[vals[ix-1]] = vals[ix]
+				}
+			}
+			return ret
+		},
+		"parseTime": time.Parse,
+		"parseYAML": func(txt string) (interface{}, error) {
+			var ret interface{}
+			err := yaml.Unmarshal([]byte(txt), &ret)
+			return ret, err
+		},
+		"toYAML": func(v interface{}) (string, error) {
+			bs, err := yaml.Marshal(v)
+			return string(bs), err
+		},
+		"parseJSON": func(txt string) (interface{}, error) {
+			var ret interface{}
+			err := json.Unmarshal([]byte(txt), &ret)
+			return ret, err
+		},
+		"toJSON": func(v interface{}) (string, error) {
+			bs, err := json.Marshal(v)
+			return string(bs), err
+		},
+	}
+
+	// extra funcs (may replace built-ins)
+	for k, fn := range oB.Funcs {
+		funcmap[k] = fn
+	}
+	curDoc := mDocs[tmplName]
+	for _, fp := range oB.funcProviders {
+		for k, fn := range fp(curDoc, mDocs) {
+			funcmap[k] = fn
+		}
+	}
+	return funcmap
+}
+
+/*
+Supplies template funcs bound to the document being rendered (`doc`), and
+to all other documents in the site (`mDocs`).
+
+NOTE: providers are also called with a zero Doc & nil DocsMap before
+templates are parsed, since all func names must be known at parse time.
+The same names must be returned on every call.
+*/
+type FuncProvider func(doc Doc, mDocs DocsMap) tt.FuncMap
+
+/*
+Adds template func `fn` as `name` (replacing any built-in of the same name).
+Must be called before Build().
+*/
+func (oB *Builder) RegisterFunc(name string, fn interface{}) (err error) {
+
+	// let text/template validate name & signature
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("RegisterFunc `%s`: %v", name, r)
+		}
+	}()
+	tt.New("").Funcs(tt.FuncMap{name: fn})
+
+	if oB.Funcs == nil {
+		oB.Funcs = make(tt.FuncMap)
+	}
+	oB.Funcs[name] = fn
+	return nil
+}
+
+/*
+Adds a per-document FuncProvider.  Funcs from later providers replace
+those of earlier providers, RegisterFunc(), and built-ins.
+Must be called before Build().
+*/
+func (oB *Builder) RegisterFuncProvider(fp FuncProvider) (err error) {
+
+	// let text/template validate names & signatures
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("RegisterFuncProvider: %v", r)
+		}
+	}()
+	tt.New("").Funcs(fp(Doc{}, nil))
+
+	oB.funcProviders = append(oB.funcProviders, fp)
+	return nil
+}
+
+func Md2HtmlWri(dst io.Writer, md []byte) error {
+	return md2html(dst, md, nil)
+}
+
+/*
+Converts markdown to HTML.  Local images are resolved through `cc`
+(nil = no dimensions or missing-image warnings).
+*/
+func md2html(dst io.Writer, md []byte, cc *convCtx) error {
+	md_enc := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Typographer,
+			extension.Table,
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(util.Prioritized(mdFigureTransformer{}, 100)),
+		),
+		goldmark.WithRendererOptions(
+			html.WithUnsafe(),
+			html.WithXHTML(),
+			renderer.WithNodeRenderers(util.Prioritized(
+				&mdImageRenderer{Config: html.NewConfig(), cc: cc}, 100,
+			)),
+		),
+	)
+	return md_enc.Convert(md, dst)
+}
+
+func Md2HtmlStr(md []byte) (string, error) {
+	var bufHtml bytes.Buffer
+	if err := Md2HtmlWri(&bufHtml, md); err != nil {
+		return "", err
+	}
+	return bufHtml.String(), nil
+}
+
+func (oB Builder) NewTemplate(tmplName string, dl vars.Delims) *tt.Template {
+	// NOTE: all funcs need to exist at Parse(),
+	//       but funcs are re-bound after Parse(), with data.
+	missingkey := "missingkey=zero"
+	if oB.IsStrict {
+		missingkey = "missingkey=error"
+	}
+	return tt.New(tmplName).
+		Delims(dl.L, dl.R).
+		Funcs(oB.funcMap("", nil, nil)).
+		Option(missingkey)
+}
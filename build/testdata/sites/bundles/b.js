@@ -0,0 +1 @@
+(function(){ console.log(a) })()
@@ -0,0 +1,97 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+const (
+	MAX_TMPL_DEPTH = 50     // max doTmpl nesting, including parent webjot runs
+	CHAIN_SEP      = " -> " // vars.ENVVAR_CHAIN separator
+)
+
+/*
+doTmpl include chain of the document being rendered, for cycle detection.
+Templates may recurse into themselves with different data (i.e. trees),
+but not with the same data.
+*/
+type tmplChain struct {
+	sInherited []string // OS paths, from parent webjot runs
+	sNames     []string // nested doTmpl names
+	sData      []interface{}
+}
+
+func newTmplChain() *tmplChain {
+	ret := &tmplChain{}
+	if s := os.Getenv(vars.ENVVAR_CHAIN); len(s) > 0 {
+		ret.sInherited = strings.Split(s, CHAIN_SEP)
+	}
+	return ret
+}
+
+/*
+Path identifying `srcpath` across webjot runs: its OS path when sources
+are on disk.
+*/
+func (oB Builder) chainPath(srcpath string) string {
+	if p := oB.osPath(srcpath); len(p) > 0 {
+		return p
+	}
+	return srcpath
+}
+
+func (tc *tmplChain) format(sNext ...string) string {
+	s := append(append(append([]string(nil), tc.sInherited...), tc.sNames...), sNext...)
+	return strings.Join(s, CHAIN_SEP)
+}
+
+/*
+Enters template `name` w/ `data`.  Errors on cycles, or when nesting
+exceeds MAX_TMPL_DEPTH.
+*/
+func (oB Builder) pushChain(tc *tmplChain, name string, data interface{}) error {
+
+	if len(tc.sInherited)+len(tc.sNames) >= MAX_TMPL_DEPTH {
+		return fmt.Errorf("doTmpl depth limit (%d) exceeded: %s", MAX_TMPL_DEPTH, tc.format(name))
+	}
+	for ix, prev := range tc.sNames {
+		if (prev == name) && reflect.DeepEqual(tc.sData[ix], data) {
+			return fmt.Errorf("doTmpl cycle: %s", tc.format(name))
+		}
+	}
+	osName := oB.chainPath(name)
+	for _, prev := range tc.sInherited {
+		if prev == osName {
+			return fmt.Errorf("nested webjot cycle: %s", tc.format(name))
+		}
+	}
+
+	tc.sNames = append(tc.sNames, name)
+	tc.sData = append(tc.sData, data)
+	return nil
+}
+
+func (tc *tmplChain) pop() {
+	if n := len(tc.sNames); n > 0 {
+		tc.sNames = tc.sNames[:n-1]
+		tc.sData = tc.sData[:n-1]
+	}
+}
+
+/*
+Value of vars.ENVVAR_CHAIN for commands run while rendering `curName`.
+*/
+func (oB Builder) chainEnv(tc *tmplChain, curName string) string {
+	s := append([]string(nil), tc.sInherited...)
+	if len(tc.sNames) == 0 {
+		s = append(s, oB.chainPath(curName))
+	}
+	for _, n := range tc.sNames {
+		s = append(s, oB.chainPath(n))
+	}
+	return strings.Join(s, CHAIN_SEP)
+}
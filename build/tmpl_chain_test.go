@@ -0,0 +1,170 @@
+package build_test
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/build/buildtest"
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+func TestTmplChain(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte(`{{ doTmpl .DOC_KEY . }}`)},
+		"a.html":              {Data: []byte(`A{{ doTmpl "b.html" . }}`)},
+		"b.html":              {Data: []byte(`B{{ doTmpl "a.html" . }}`)},
+		"self.html":           {Data: []byte(`S{{ doTmpl "self.html" . }}`)},
+		"deep.html":           {Data: []byte(`D{{ doTmpl "deep.html" (print . "+") }}`)},
+		// recursion w/ changing data is fine, when it ends
+		"tree.html": {Data: []byte(`T{{ doTmpl "leaf.html" "" }}`)},
+		"leaf.html": {Data: []byte(`{{ if lt (len .) 5 }}{{ doTmpl "leaf.html" (print . "+") }}{{ end }}`)},
+	}
+
+	_, sEvt := buildtest.BuildEvents(t, build.Options{SrcFS: fsys})
+
+	mErr := make(map[string]string)
+	for _, ev := range sEvt {
+		if ev.Kind == build.EVT_ERROR {
+			mErr[ev.Src] = ev.Err.Error()
+		}
+	}
+
+	sDeep := make([]string, build.MAX_TMPL_DEPTH)
+	for ix := range sDeep {
+		sDeep[ix] = "deep.html"
+	}
+
+	tests := []struct {
+		src   string
+		sWant []string // in error message (empty = no error)
+	}{
+		{"a.html", []string{"doTmpl cycle", "a.html -> b.html -> a.html"}},
+		{"b.html", []string{"doTmpl cycle", "b.html -> a.html -> b.html"}},
+		{"self.html", []string{"doTmpl cycle", "self.html -> self.html"}},
+		{"deep.html", []string{
+			fmt.Sprintf("depth limit (%d) exceeded", build.MAX_TMPL_DEPTH),
+			strings.Join(sDeep, build.CHAIN_SEP),
+		}},
+		{"tree.html", nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.src, func(t *testing.T) {
+			msg, ok := mErr[tc.src]
+			if len(tc.sWant) == 0 {
+				if ok {
+					t.Fatalf("unexpected error: %s", msg)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("no error")
+			}
+			for _, s := range tc.sWant {
+				if !strings.Contains(msg, s) {
+					t.Errorf("error %q lacks %q", msg, s)
+				}
+			}
+		})
+	}
+}
+
+/*
+Chains inherited from a parent webjot run (through vars.ENVVAR_CHAIN) are
+passed on to commands, and count towards cycles & MAX_TMPL_DEPTH.
+*/
+func TestTmplChainInherited(t *testing.T) {
+
+	root := t.TempDir()
+	for rel, data := range map[string]string{
+		".webjot/layout.html": `{{ doTmpl .DOC_KEY . }}`,
+		"env.html":            `{{ doCmd "sh" "-c" "printf %s \"$` + vars.ENVVAR_CHAIN + `\"" }}`,
+		"flat.html":           `F`,
+		"nested.html":         `N{{ doTmpl "flat.html" . }}`,
+	} {
+		fpath := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const outer = "/elsewhere/outer.html"
+	sDeep := make([]string, build.MAX_TMPL_DEPTH-1)
+	for ix := range sDeep {
+		sDeep[ix] = fmt.Sprintf("/elsewhere/%d.html", ix)
+	}
+
+	tests := []struct {
+		name  string
+		chain []string
+		mWant map[string]string // by source: output, or `!` + error substring
+	}{
+		{
+			name:  "passed on",
+			chain: []string{outer},
+			mWant: map[string]string{
+				"env.html":    outer + build.CHAIN_SEP + filepath.Join(root, "env.html"),
+				"nested.html": "NF",
+			},
+		},
+		{
+			name:  "cycle",
+			chain: []string{outer, filepath.Join(root, "nested.html")},
+			mWant: map[string]string{
+				"nested.html": "!nested webjot cycle: " + outer + build.CHAIN_SEP +
+					filepath.Join(root, "nested.html") + build.CHAIN_SEP + "nested.html",
+				"flat.html": "F",
+			},
+		},
+		{
+			name:  "depth",
+			chain: sDeep,
+			mWant: map[string]string{
+				"nested.html": fmt.Sprintf("!doTmpl depth limit (%d) exceeded", build.MAX_TMPL_DEPTH),
+				"flat.html":   "F",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			t.Setenv(vars.ENVVAR_CHAIN, strings.Join(tc.chain, build.CHAIN_SEP))
+			pub, sEvt := buildtest.BuildEvents(t, build.Options{SrcDir: root})
+
+			mErr := make(map[string]string)
+			for _, ev := range sEvt {
+				mErr[ev.Src] = ev.Err.Error()
+			}
+			for src, want := range tc.mWant {
+				if strings.HasPrefix(want, "!") {
+					if !strings.Contains(mErr[src], want[1:]) {
+						t.Errorf("%s: got error %q, want %q", src, mErr[src], want[1:])
+					}
+					continue
+				}
+				if msg, ok := mErr[src]; ok {
+					t.Errorf("%s: unexpected error: %s", src, msg)
+					continue
+				}
+				bs, err := fs.ReadFile(pub, src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(bs) != want {
+					t.Errorf("%s: got %q, want %q", src, bs, want)
+				}
+			}
+		})
+	}
+}
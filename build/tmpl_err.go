@@ -0,0 +1,103 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Template parse or execution error, located within its source file.
+*/
+type TemplateError struct {
+	File    string `json:"file"`              // source path, relative to site root
+	Line    int    `json:"line"`              // within the file, including its header
+	Col     int    `json:"col,omitempty"`     // 1-based, 0 = unknown
+	Msg     string `json:"msg"`               // error, minus location
+	Excerpt string `json:"excerpt,omitempty"` // offending source line, w/ caret below
+}
+
+/*
+Formatted as `file:line:col: msg`, like compiler output.
+*/
+func (te *TemplateError) Error() string {
+	if te.Col > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", te.File, te.Line, te.Col, te.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", te.File, te.Line, te.Msg)
+}
+
+// `template: NAME:LINE[:COL]: MSG`
+var rxTmplErr = regexp.MustCompile(`(?s)^template: (.+?):(\d+):(?:(\d+):)? (.*)$`)
+
+/*
+Converts text/template errors into *TemplateError, with line numbers
+relative to the source file (rather than the body below its header).
+Errors already converted (i.e. inside doTmpl) are passed through, so the
+innermost template is reported.  Others are returned as-is.
+*/
+func (oB Builder) templateErr(err error) error {
+
+	if err == nil {
+		return nil
+	}
+	var te *TemplateError
+	if errors.As(err, &te) {
+		return te
+	}
+	m := rxTmplErr.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+
+	te = &TemplateError{File: m[1], Msg: m[4]}
+	te.Line, _ = strconv.Atoi(m[2])
+	if len(m[3]) > 0 {
+		// text/template columns are 0-based byte offsets
+		te.Col, _ = strconv.Atoi(m[3])
+		te.Col++
+	}
+	te.Msg = strings.TrimPrefix(te.Msg, fmt.Sprintf("executing %q ", te.File))
+
+	// header offset & excerpt
+	dp, e2 := LoadDocProps(oB.SrcFS, te.File, oB.rxHdrDelim)
+	if e2 != nil {
+		return te
+	}
+	sLines := bytes.Split(dp.Source, []byte("\n"))
+	if (te.Line > 0) && (te.Line <= len(sLines)) {
+		te.Excerpt = excerpt(
+			string(bytes.TrimRight(sLines[te.Line-1], "\r")),
+			te.Line+dp.BodyLine-1,
+			te.Col,
+		)
+	}
+	te.Line += dp.BodyLine - 1
+	return te
+}
+
+/*
+Formats source line `text` with its line number, and a caret under
+column `col` (when known).
+*/
+func excerpt(text string, line, col int) string {
+
+	num := strconv.Itoa(line)
+	ret := fmt.Sprintf("%s | %s", num, text)
+	if (col < 1) || (col > len(text)+1) {
+		return ret
+	}
+
+	// keep tabs, so caret lines up
+	var pad strings.Builder
+	for _, c := range text[:col-1] {
+		if c != '\t' {
+			c = ' '
+		}
+		pad.WriteRune(c)
+	}
+	return ret + fmt.Sprintf("\n%s | %s^", strings.Repeat(" ", len(num)), pad.String())
+}
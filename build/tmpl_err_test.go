@@ -0,0 +1,159 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExcerpt(t *testing.T) {
+
+	tests := []struct {
+		name string
+		text string
+		line int
+		col  int
+		want string
+	}{
+		{"no column", "a {{ .b }}", 3, 0, "3 | a {{ .b }}"},
+		{"first column", "a {{ .b }}", 3, 1, "3 | a {{ .b }}\n  | ^"},
+		{"column", "a {{ .b }}", 12, 3, "12 | a {{ .b }}\n   |   ^"},
+		{"end of line", "ab", 7, 3, "7 | ab\n  |   ^"},
+		{"past end of line", "ab", 7, 4, "7 | ab"},
+		{"tabs kept", "\t\ta {{ .b }}", 1, 5, "1 | \t\ta {{ .b }}\n  | \t\t  ^"},
+		// columns are byte offsets, carets are per rune
+		{"multibyte", "é {{ .b }}", 1, 4, "1 | é {{ .b }}\n  |   ^"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := excerpt(tc.text, tc.line, tc.col); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTemplateErr(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte("{{ doTmpl .DOC_KEY . }}")},
+		"head.html":           {Data: []byte("title: T\nauthor: A\n@@@@@@@\none\n\ttwo {{ .x }}\n")},
+		"bare.html":           {Data: []byte("one\ntwo\n")},
+	}
+	pB, err := New(Options{SrcFS: fsys, Sink: NewMemSink()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teInner := &TemplateError{File: "inner.html", Line: 9, Msg: "inner"}
+
+	tests := []struct {
+		name string
+		err  error
+		want *TemplateError // nil = returned as-is
+	}{
+		{
+			name: "header offset & column",
+			err:  errors.New(`template: head.html:2:6: executing "head.html" at <.x>: boom`),
+			want: &TemplateError{
+				File: "head.html", Line: 5, Col: 7, Msg: "at <.x>: boom",
+				Excerpt: "5 | \ttwo {{ .x }}\n  | \t     ^",
+			},
+		},
+		{
+			name: "no column",
+			err:  errors.New(`template: head.html:1: unexpected EOF`),
+			want: &TemplateError{File: "head.html", Line: 4, Msg: "unexpected EOF", Excerpt: "4 | one"},
+		},
+		{
+			name: "no header",
+			err:  errors.New(`template: bare.html:2:0: boom`),
+			want: &TemplateError{File: "bare.html", Line: 2, Col: 1, Msg: "boom", Excerpt: "2 | two\n  | ^"},
+		},
+		{
+			name: "line past end",
+			err:  errors.New(`template: bare.html:9: boom`),
+			want: &TemplateError{File: "bare.html", Line: 9, Msg: "boom"},
+		},
+		{
+			name: "unknown file",
+			err:  errors.New(`template: nope.html:2:3: boom`),
+			want: &TemplateError{File: "nope.html", Line: 2, Col: 4, Msg: "boom"},
+		},
+		{
+			name: "innermost kept",
+			err:  fmt.Errorf(`template: head.html:2:6: error calling doTmpl: %w`, teInner),
+			want: teInner,
+		},
+		{
+			name: "not a template error",
+			err:  errors.New("boom"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := pB.templateErr(tc.err)
+			if tc.want == nil {
+				if got != tc.err {
+					t.Errorf("got %#v, want error as-is", got)
+				}
+				return
+			}
+			var te *TemplateError
+			if !errors.As(got, &te) {
+				t.Fatalf("got %T, want *TemplateError", got)
+			}
+			if *te != *tc.want {
+				t.Errorf("got:\n%#v\nwant:\n%#v", *te, *tc.want)
+			}
+		})
+	}
+}
+
+/*
+Errors inside nested doTmpl calls are located in the innermost file.
+*/
+func TestTemplateErrNested(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte("<main>\n{{ doTmpl .DOC_KEY . }}\n</main>")},
+		"outer.html":          {Data: []byte("a\n{{ doTmpl \"middle.html\" . }}\n")},
+		"middle.html":         {Data: []byte("skip: true\n@@@@@@@\nb\n  {{ doTmpl \"inner.html\" . }}\n")},
+		"inner.html":          {Data: []byte("skip: true\nx: 1\n@@@@@@@\nc\n\n{{ index .nope 1 }}\n")},
+	}
+
+	var sErr []error
+	pB, err := New(Options{
+		SrcFS:       fsys,
+		Sink:        NewMemSink(),
+		IsKeepGoing: true,
+		OnEvent: func(ev Event) {
+			if ev.Kind == EVT_ERROR {
+				sErr = append(sErr, ev.Err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pB.Build(); !errors.Is(err, ErrBuildFailed) {
+		t.Fatalf("got %v, want ErrBuildFailed", err)
+	}
+
+	if len(sErr) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(sErr), sErr)
+	}
+	var te *TemplateError
+	if !errors.As(sErr[0], &te) {
+		t.Fatalf("got %T, want *TemplateError", sErr[0])
+	}
+	if (te.File != "inner.html") || (te.Line != 6) || (te.Col != 4) {
+		t.Errorf("located at %s:%d:%d, want inner.html:6:4", te.File, te.Line, te.Col)
+	}
+	if want := "6 | {{ index .nope 1 }}\n  |    ^"; te.Excerpt != want {
+		t.Errorf("got excerpt:\n%s\nwant:\n%s", te.Excerpt, want)
+	}
+}
@@ -0,0 +1,151 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ErrMsg struct {
+	err error
+	msg string
+}
+
+func EWrap(err error, msg string) ErrMsg {
+	return ErrMsg{err: err, msg: msg}
+}
+
+func (e ErrMsg) Error() string {
+	return fmt.Sprintf("[%s] %v", e.msg, e.err)
+}
+
+func (e ErrMsg) Unwrap() error {
+	return e.err
+}
+
+func (e ErrMsg) Message() string {
+	return e.msg
+}
+
+/*
+Writes cache file `dst` (creating its dir), via write-then-rename, so
+readers never see partial entries.
+*/
+func writeCacheFile(dst string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+/*
+copies src (described by iSrc) to dst if:
+  - destination does not exist, OR
+  - destination has different size than source, OR
+  - destination has different mtime than source
+*/
+func CopyOnDirty(dst string, src io.Reader, iSrc fs.FileInfo, fileMode os.FileMode) error {
+
+	srcTime := iSrc.ModTime().Round(time.Second)
+	fnCopy := func() error {
+		err := func() error {
+			flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+			fDst, err := os.OpenFile(dst, flags, fileMode)
+			if err != nil {
+				return err
+			}
+			defer fDst.Close()
+			_, err = io.Copy(fDst, src)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(dst, srcTime, srcTime)
+	}
+
+	iDst, err := os.Stat(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fnCopy()
+		} else {
+			return err
+		}
+	}
+	dstTime := iDst.ModTime().Round(time.Second)
+
+	// copy if modified (fuzzy check)
+	if (iDst.Size() != iSrc.Size()) || (dstTime != srcTime) {
+		return fnCopy()
+	}
+
+	return nil
+}
+
+func SearchDirAncestors(start, needle string) (found string, err error) {
+
+	start, err = filepath.Abs(start)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			err = EWrap(err,
+				fmt.Sprintf("search for `%s` in `%s` ancestors", needle, start),
+			)
+		}
+	}()
+
+	cur := start
+	inf, err := os.Stat(cur)
+	if err != nil {
+		return
+	}
+
+	if !inf.IsDir() {
+		cur = filepath.Dir(cur)
+	}
+
+	if filepath.Base(cur) == needle {
+		found = cur
+		return
+	}
+
+	for {
+
+		// check siblings
+		var sD []os.DirEntry
+		if sD, err = os.ReadDir(cur); err != nil {
+			return
+		}
+		for ix := range sD {
+			if sD[ix].IsDir() {
+				sname := sD[ix].Name()
+				if sname == needle {
+					found = filepath.Join(cur, sname)
+					return
+				}
+			}
+		}
+
+		// up a dir
+		cur = filepath.Dir(cur)
+
+		// exit at root
+		if strings.HasSuffix(cur, string(filepath.Separator)) {
+			break
+		}
+	}
+
+	err = os.ErrNotExist
+	return
+}
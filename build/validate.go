@@ -0,0 +1,291 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// elements w/o content or end tags
+var voidElems = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// elements whose end tags may be omitted
+var optEndElems = map[string]bool{
+	"html": true, "head": true, "body": true, "p": true, "li": true,
+	"dt": true, "dd": true, "option": true, "optgroup": true, "colgroup": true,
+	"caption": true, "thead": true, "tbody": true, "tfoot": true, "tr": true,
+	"td": true, "th": true, "rb": true, "rt": true, "rtc": true, "rp": true,
+}
+
+/*
+An element closed implicitly by a start tag: the nearest open one of
+sClose, unless one of sBound is open nearer.
+*/
+type implicitClose struct {
+	sClose []string
+	sBound []string
+}
+
+// bounds of "button scope", which `<p>` is closed within
+var scopeElems = []string{
+	"applet", "button", "caption", "html", "marquee", "object", "table",
+	"td", "template", "th",
+}
+
+/*
+Start tags that close open elements, per the HTML5 tree construction rules
+(i.e. `<div>` closes an open `<p>`, `<td>` an open cell).
+*/
+var implicitCloses = func() map[string][]implicitClose {
+
+	closeP := implicitClose{sClose: []string{"p"}, sBound: scopeElems}
+	ret := map[string][]implicitClose{
+		"li":       {closeP, {sClose: []string{"li"}, sBound: append([]string{"ul", "ol"}, scopeElems...)}},
+		"dd":       {closeP, {sClose: []string{"dd", "dt"}, sBound: append([]string{"dl"}, scopeElems...)}},
+		"dt":       {closeP, {sClose: []string{"dd", "dt"}, sBound: append([]string{"dl"}, scopeElems...)}},
+		"td":       {{sClose: []string{"td", "th"}, sBound: []string{"tr", "table", "template"}}},
+		"th":       {{sClose: []string{"td", "th"}, sBound: []string{"tr", "table", "template"}}},
+		"tr":       {{sClose: []string{"tr"}, sBound: []string{"tbody", "thead", "tfoot", "table", "template"}}},
+		"option":   {{sClose: []string{"option"}, sBound: []string{"select", "datalist", "optgroup"}}},
+		"optgroup": {{sClose: []string{"option", "optgroup"}, sBound: []string{"select", "datalist"}}},
+	}
+	for _, k := range []string{"tbody", "thead", "tfoot"} {
+		ret[k] = []implicitClose{{sClose: []string{"tbody", "thead", "tfoot"}, sBound: []string{"table", "template"}}}
+	}
+	for _, k := range []string{
+		"address", "article", "aside", "blockquote", "center", "details",
+		"dialog", "dir", "div", "dl", "fieldset", "figcaption", "figure",
+		"footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header",
+		"hgroup", "hr", "listing", "main", "menu", "nav", "ol", "p", "pre",
+		"search", "section", "summary", "table", "ul", "xmp",
+	} {
+		ret[k] = []implicitClose{closeP}
+	}
+	return ret
+}()
+
+/*
+Index of the nearest open element closed by `ic`, or -1.
+*/
+func (ic implicitClose) find(sOpen []openElem) int {
+	for ix := len(sOpen) - 1; ix >= 0; ix-- {
+		name := sOpen[ix].name
+		for _, s := range ic.sClose {
+			if s == name {
+				return ix
+			}
+		}
+		for _, s := range ic.sBound {
+			if s == name {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+/*
+Structural problem in an HTML page.
+*/
+type htmlIssue struct {
+	line    int      // within the page
+	sNeedle []string // text locating the problem in sources
+	msg     string
+}
+
+type openElem struct {
+	name string
+	line int
+	raw  string
+}
+
+/*
+Checks HTML `r` for unclosed & stray elements, duplicate IDs, images w/o
+`alt`, and (in full documents) a missing `<title>` or `<html lang>`.
+Elements closed implicitly per HTML5 (i.e. an open `<p>` by `<div>`) are
+fine, but not the unclosed elements within them.
+*/
+func validatePage(r io.Reader) ([]htmlIssue, error) {
+
+	var ret []htmlIssue
+	var sOpen []openElem
+	mIds := make(map[string]int)
+	bHtml, bTitle, bInTitle := false, false, false
+	htmlLine, htmlRaw := 0, ""
+
+	fnUnclosed := func(oe openElem, suffix string) {
+		ret = append(ret, htmlIssue{
+			line: oe.line, sNeedle: []string{oe.raw},
+			msg: fmt.Sprintf("`<%s>` not closed%s", oe.name, suffix),
+		})
+	}
+
+	// closes sOpen[ix] & those above it, by tag `by`
+	fnClose := func(ix int, by string) {
+		for _, oe := range sOpen[ix+1:] {
+			if !optEndElems[oe.name] {
+				fnUnclosed(oe, fmt.Sprintf(" before `%s`", by))
+			}
+		}
+		sOpen = sOpen[:ix]
+	}
+
+	z := html.NewTokenizer(r)
+	line := 1
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if !errors.Is(z.Err(), io.EOF) {
+				return ret, z.Err()
+			}
+			break
+		}
+		tokLine := line
+		raw := string(z.Raw())
+		line += strings.Count(raw, "\n")
+
+		tok := z.Token()
+		switch tt {
+
+		case html.TextToken:
+			if bInTitle && (len(strings.TrimSpace(tok.Data)) > 0) {
+				bTitle = true
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+
+			// text/template renders missing vars as `<no value>`
+			if raw == "<no value>" {
+				ret = append(ret, htmlIssue{line: tokLine, msg: "missing variable (rendered as `<no value>`)"})
+				continue
+			}
+
+			for _, attr := range tok.Attr {
+				if attr.Key != "id" {
+					continue
+				}
+				if first, ok := mIds[attr.Val]; ok {
+					ret = append(ret, htmlIssue{
+						line:    tokLine,
+						sNeedle: []string{raw, `id="` + attr.Val + `"`},
+						msg:     fmt.Sprintf("duplicate id `%s` (first at line %d of output)", attr.Val, first),
+					})
+				} else {
+					mIds[attr.Val] = tokLine
+				}
+			}
+
+			switch tok.Data {
+			case "html":
+				bHtml, htmlLine, htmlRaw = true, tokLine, raw
+				lang := ""
+				for _, attr := range tok.Attr {
+					if attr.Key == "lang" {
+						lang = strings.TrimSpace(attr.Val)
+					}
+				}
+				if len(lang) == 0 {
+					ret = append(ret, htmlIssue{line: tokLine, sNeedle: []string{raw}, msg: "`<html>` missing `lang`"})
+				}
+			case "img":
+				bAlt := false
+				for _, attr := range tok.Attr {
+					bAlt = bAlt || (attr.Key == "alt")
+				}
+				if !bAlt {
+					ret = append(ret, htmlIssue{line: tokLine, sNeedle: []string{raw}, msg: "`<img>` missing `alt`"})
+				}
+			case "title":
+				bInTitle = true
+			}
+
+			for _, ic := range implicitCloses[tok.Data] {
+				if ix := ic.find(sOpen); ix >= 0 {
+					fnClose(ix, "<"+tok.Data+">")
+				}
+			}
+
+			// self-closing syntax is only meaningful in SVG & MathML
+			if (tt == html.StartTagToken) && !voidElems[tok.Data] {
+				sOpen = append(sOpen, openElem{name: tok.Data, line: tokLine, raw: raw})
+			}
+
+		case html.EndTagToken:
+
+			if tok.Data == "title" {
+				bInTitle = false
+			}
+			if voidElems[tok.Data] {
+				continue
+			}
+
+			ix := len(sOpen) - 1
+			for ; (ix >= 0) && (sOpen[ix].name != tok.Data); ix-- {
+			}
+			if ix < 0 {
+				ret = append(ret, htmlIssue{
+					line: tokLine, sNeedle: []string{raw},
+					msg: fmt.Sprintf("stray `</%s>` (no open `<%s>`)", tok.Data, tok.Data),
+				})
+				continue
+			}
+			fnClose(ix, "</"+tok.Data+">")
+		}
+	}
+
+	for _, oe := range sOpen {
+		if !optEndElems[oe.name] {
+			fnUnclosed(oe, "")
+		}
+	}
+	if bHtml && !bTitle {
+		ret = append(ret, htmlIssue{line: htmlLine, sNeedle: []string{htmlRaw}, msg: "missing `<title>`"})
+	}
+	return ret, nil
+}
+
+/*
+Validates HTML output of the most recent Build(), reporting each problem
+as an EVT_WARN (or EVT_ERROR, in strict mode).
+*/
+func (oB Builder) Validate() error {
+
+	mHtml, sPages, err := oB.htmlPages()
+	if err != nil {
+		return EWrap(err, "validation")
+	}
+
+	kind := EVT_WARN
+	if oB.IsStrict {
+		kind = EVT_ERROR
+	}
+
+	nIssues := 0
+	for _, page := range sPages {
+		sIssue, err := validatePage(bytes.NewReader(mHtml[page]))
+		if err != nil {
+			return EWrap(err, page)
+		}
+		if len(sIssue) == 0 {
+			continue
+		}
+		nIssues += len(sIssue)
+		pageSrc := strings.Split(string(mHtml[page]), "\n")
+		for _, iss := range sIssue {
+			oB.emit(oB.pageEvent(kind, page, oB.pageErr(page, pageSrc, iss.line, iss.sNeedle, iss.msg)))
+		}
+	}
+
+	if oB.IsStrict && (nIssues > 0) {
+		return fmt.Errorf("%d HTML problem(s)", nIssues)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package build
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidatePage(t *testing.T) {
+
+	// full document boilerplate
+	const head = "<!DOCTYPE html>\n<html lang=\"en\">\n<head><title>T</title></head>\n<body>\n"
+
+	tests := []struct {
+		name  string
+		html  string
+		sWant []string // `line: msg`
+	}{
+		{
+			"fragment ok",
+			"<div><p>a<p>b</div>\n<img src=\"a.png\" alt=\"\">\n<br/>\n<svg><path d=\"\"/></svg>",
+			nil,
+		},
+		{
+			"document ok",
+			head + "<ul><li>a<li>b</ul>\n<table><tr><td>a<td>b</table>\n",
+			nil,
+		},
+		{
+			"implicit closes",
+			"<p>a<div>b</div>\n<p>c<ul><li>d<li>e</ul>\n<table><tr><td>f<td>g<tr><td>h</table>\n" +
+				"<dl><dt>i<dd>j<dt>k</dl>\n<select><option>l<optgroup><option>m</select>",
+			nil,
+		},
+		{
+			"closed by implicit close",
+			"<p><span>a\n<div>b</div>",
+			[]string{"1: `<span>` not closed before `<div>`"},
+		},
+		{
+			"end tag of implicitly closed",
+			"<p>a\n<div>b</div>\n</p>",
+			[]string{"3: stray `</p>` (no open `<p>`)"},
+		},
+		{
+			"button scope",
+			"<p><button>\n<div>a</div></button></p>",
+			nil,
+		},
+		{
+			"unclosed",
+			"<div>\n<span>a\n",
+			[]string{"1: `<div>` not closed", "2: `<span>` not closed"},
+		},
+		{
+			"unclosed before end tag",
+			"<div>\n<span>a\n</div>",
+			[]string{"2: `<span>` not closed before `</div>`"},
+		},
+		{
+			"stray end tag",
+			"<p>a</p>\n</div>",
+			[]string{"2: stray `</div>` (no open `<div>`)"},
+		},
+		{
+			"void end tag",
+			"<br></br>",
+			nil,
+		},
+		{
+			"duplicate id",
+			"<h2 id=\"a\">x</h2>\n<p id=\"b\">\n<div id=\"a\"></div>",
+			[]string{"3: duplicate id `a` (first at line 1 of output)"},
+		},
+		{
+			"missing alt",
+			"<img src=\"a.png\">\n<img alt=\"x\" src=\"b.png\"/>",
+			[]string{"1: `<img>` missing `alt`"},
+		},
+		{
+			"missing title & lang",
+			"<html>\n<head></head>\n<body></body>\n</html>",
+			[]string{"1: `<html>` missing `lang`", "1: missing `<title>`"},
+		},
+		{
+			"empty title",
+			"<html lang=\"en\">\n<head><title> </title></head>\n</html>",
+			[]string{"1: missing `<title>`"},
+		},
+		{
+			"missing variable",
+			"<p>\nHello, <no value>!\n</p>",
+			[]string{"2: missing variable (rendered as `<no value>`)"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sIssue, err := validatePage(strings.NewReader(tc.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sGot []string
+			for _, iss := range sIssue {
+				sGot = append(sGot, strconv.Itoa(iss.line)+": "+iss.msg)
+			}
+			if strings.Join(sGot, "\n") != strings.Join(tc.sWant, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(sGot, "\n"), strings.Join(tc.sWant, "\n"))
+			}
+		})
+	}
+}
+
+/*
+Validation problems are located in sources only by unambiguous needles.
+*/
+func TestValidateLocation(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		".webjot/layout.html": {Data: []byte("<main>\n{{ doTmpl .DOC_KEY . }}\n</main>\n")},
+		// generic needle: occurs twice, so only the output line is known
+		"generic.html": {Data: []byte("<span>a</span>\n<p>b</p>\n<span>c\n")},
+		// unique needle
+		"unique.html":  {Data: []byte("<p>a</p>\n<span class=\"x\">b\n")},
+		"novalue.html": {Data: []byte("<p>\nHello, {{ .name }}!\n</p>\n")},
+	}
+
+	var sEvt []Event
+	pB, err := New(Options{
+		SrcFS: fsys,
+		Sink:  NewMemSink(),
+		OnEvent: func(ev Event) {
+			if (ev.Kind == EVT_ERROR) || (ev.Kind == EVT_WARN) {
+				sEvt = append(sEvt, ev)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pB.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if err = pB.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mWant := map[string]string{
+		"generic.html": ".pub/generic.html:4: `<span>` not closed before `</main>` (layout .webjot/layout.html)",
+		"unique.html":  "unique.html:2: `<span>` not closed before `</main>`",
+		"novalue.html": ".pub/novalue.html:3: missing variable (rendered as `<no value>`) (layout .webjot/layout.html)",
+	}
+	if len(sEvt) != len(mWant) {
+		t.Fatalf("got %d events, want %d: %v", len(sEvt), len(mWant), sEvt)
+	}
+	for _, ev := range sEvt {
+		if ev.Kind != EVT_WARN {
+			t.Errorf("[%s] got kind %v, want EVT_WARN", ev.Src, ev.Kind)
+		}
+		var te *TemplateError
+		if !errors.As(ev.Err, &te) {
+			t.Errorf("[%s] got %T, want *TemplateError", ev.Src, ev.Err)
+		}
+		if got := ev.Err.Error(); got != mWant[ev.Src] {
+			t.Errorf("[%s] got %q, want %q", ev.Src, got, mWant[ev.Src])
+		}
+	}
+}
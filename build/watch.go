@@ -0,0 +1,167 @@
+package build
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BourgeoisBear/webjot/vars"
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+watches for changes to source and config files
+re-builds on change
+NOTE: blocking channel-select loop
+NOTE: requires sources on disk
+*/
+func (oB *Builder) Watch(rwm *sync.RWMutex) error {
+
+	if len(oB.SrcRoot) == 0 {
+		return errors.New("watch mode requires site sources on disk")
+	}
+
+	if oB.mL2D == nil {
+		oB.mL2D = make(Layout2Docs)
+		oB.mLo = make(Layouts)
+		oB.digests = make(map[string]string)
+		oB.images = make(map[string]Image)
+		oB.deps = make(depMap)
+		oB.pages = make(map[string]Doc)
+		oB.outputs = make(map[string]bool)
+		oB.pending = make(pendingMap)
+		oB.imgRefs = make(map[string]bool)
+	}
+
+	// create new pW
+	pW, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer pW.Close()
+
+	// add src dirs to watch
+	err = filepath.WalkDir(
+		oB.SrcRoot,
+		func(src string, info fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// only watch non-hidden dirs
+			if !info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+				return nil
+			}
+			return pW.Add(src)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// add conf dir to watch
+	if err = pW.Add(oB.ConfDir); err != nil {
+		return err
+	}
+
+	// imported stylesheets may live outside watched dirs (i.e. under CFGDIR)
+	mWatched := make(map[string]bool)
+	fnWatchDeps := func() {
+		for dep := range oB.deps {
+			dir := filepath.Join(oB.SrcRoot, filepath.FromSlash(path.Dir(dep)))
+			if mWatched[dir] {
+				continue
+			}
+			mWatched[dir] = true
+			if err := pW.Add(dir); err != nil {
+				oB.emit(Event{Kind: EVT_ERROR, Src: dep, Err: err})
+			}
+		}
+	}
+	fnWatchDeps()
+
+	vinit := vars.GetEnvGlobals()
+
+	// listen for events
+	for {
+		select {
+		case evt, ok := <-pW.Events:
+			if !ok {
+				return nil
+			}
+
+			// rebuild file
+			if evt.Has(fsnotify.Write) || evt.Has(fsnotify.Create) {
+
+				// skip paths in PubDir
+				if strings.HasPrefix(evt.Name, oB.PubDir) {
+					continue
+				}
+				// skip hidden
+				if strings.HasPrefix(filepath.Base(evt.Name), ".") {
+					continue
+				}
+				// skip dirs
+				fi, err := os.Stat(evt.Name)
+				if err != nil {
+					oB.emit(Event{Kind: EVT_ERROR, Src: evt.Name, Err: err})
+					continue
+				}
+				if fi.IsDir() {
+					continue
+				}
+
+				// source path, relative to site root
+				srcpath, err := filepath.Rel(oB.SrcRoot, evt.Name)
+				if err != nil {
+					oB.emit(Event{Kind: EVT_ERROR, Src: evt.Name, Err: err})
+					continue
+				}
+				srcpath = filepath.ToSlash(srcpath)
+
+				msg := evt.Op.String()
+				sDeps := oB.deps.dependents(srcpath)
+				if len(sDeps) > 0 {
+					msg += ", imported by " + strings.Join(sDeps, ", ")
+				}
+				oB.emit(Event{Kind: EVT_CHANGE, Src: evt.Name, Msg: msg})
+
+				func() {
+					// mutexing between HTTP:HEAD and writes to /.pub/
+					// (for live.js issues w/ files in the process of being written)
+					rwm.Lock()
+					defer rwm.Unlock()
+
+					_, _, err := oB.BuildFile(srcpath, vinit, oB.mL2D, oB.mLo)
+					if err != nil {
+						return
+					}
+
+					// re-build documents importing the changed file
+					for _, dep := range sDeps {
+						if dep == srcpath {
+							continue
+						}
+						if _, _, err = oB.BuildFile(dep, vinit, oB.mL2D, oB.mLo); err != nil {
+							return
+						}
+					}
+
+					// TODO: track dependency graph, only re-build dirty
+					// parse layouts, render nested templates
+					oB.ApplyLayouts(oB.mL2D, oB.mLo)
+					fnWatchDeps()
+				}()
+			}
+
+		case err, ok := <-pW.Errors:
+			if !ok {
+				return nil
+			}
+			oB.emit(Event{Kind: EVT_ERROR, Err: err})
+		}
+	}
+}
@@ -1,13 +1,19 @@
 module github.com/BourgeoisBear/webjot
 
-go 1.19
+go 1.20
 
 require (
-	github.com/fsnotify/fsnotify v1.6.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/mattn/go-isatty v0.0.18
+	github.com/tdewolff/minify/v2 v2.20.37
 	github.com/yosssi/gcss v0.1.0
 	github.com/yuin/goldmark v1.5.4
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.26.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.6.0 // indirect
+require (
+	github.com/tdewolff/parse/v2 v2.7.15 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/vars"
+)
+
+const (
+	LOGFMT_TEXT = "text"
+	LOGFMT_JSON = "json"
+)
+
+/*
+One line of `-log-format json` output.
+*/
+type jsonEvent struct {
+	Phase      string     `json:"phase"`
+	Src        string     `json:"src,omitempty"`
+	Dst        string     `json:"dst,omitempty"`
+	DocType    string     `json:"doc_type,omitempty"`
+	DurationMs float64    `json:"duration_ms,omitempty"`
+	Msg        string     `json:"msg,omitempty"`
+	Error      *jsonError `json:"error,omitempty"`
+	Vars       vars.Vars  `json:"vars,omitempty"`
+	*jsonSummary
+}
+
+type jsonError struct {
+	Msg     string `json:"msg"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Col     int    `json:"col,omitempty"`
+	Excerpt string `json:"excerpt,omitempty"`
+}
+
+type jsonSummary struct {
+	Rendered int  `json:"rendered"`
+	Copied   int  `json:"copied"`
+	Skipped  int  `json:"skipped"`
+	Warnings int  `json:"warnings"`
+	Errors   int  `json:"errors"`
+	IsOk     bool `json:"ok"`
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+/*
+Locates err in file `src`, unless it's a template error (which knows its
+own location).
+*/
+func toJsonError(err error, src string) *jsonError {
+	if err == nil {
+		return nil
+	}
+	var te *build.TemplateError
+	if errors.As(err, &te) {
+		return &jsonError{
+			Msg: te.Msg, File: te.File, Line: te.Line, Col: te.Col, Excerpt: te.Excerpt,
+		}
+	}
+	return &jsonError{Msg: err.Error(), File: src}
+}
+
+/*
+Reports build events, the final summary, and fatal errors as colored text,
+or as JSON lines.
+*/
+type cliLogger struct {
+	fnText    build.EventFunc
+	enc       *json.Encoder
+	mu        sync.Mutex // JSON lines may come from watch-mode goroutines
+	bIsTty    bool
+	bShowVars bool
+}
+
+func newCliLogger(format string, iWri io.Writer, bIsTty, bShowVars bool) (*cliLogger, error) {
+	ret := &cliLogger{bIsTty: bIsTty, bShowVars: bShowVars}
+	switch format {
+	case LOGFMT_TEXT:
+		ret.fnText = cliEventHandler(bIsTty, bShowVars)
+	case LOGFMT_JSON:
+		ret.enc = json.NewEncoder(iWri)
+		ret.enc.SetEscapeHTML(false)
+	default:
+		return nil, fmt.Errorf("invalid -log-format value `%s`", format)
+	}
+	return ret, nil
+}
+
+func (cl *cliLogger) write(je jsonEvent) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if err := cl.enc.Encode(je); err != nil {
+		// i.e. vars that can't be encoded
+		je.Vars = nil
+		je.Msg = err.Error()
+		cl.enc.Encode(je)
+	}
+}
+
+func (cl *cliLogger) Event(ev build.Event) {
+
+	if cl.enc == nil {
+		cl.fnText(ev)
+		return
+	}
+
+	je := jsonEvent{
+		Phase:      ev.Kind.String(),
+		Src:        ev.Src,
+		Dst:        ev.Dst,
+		DurationMs: durationMs(ev.Elapsed),
+		Msg:        ev.Msg,
+		Error:      toJsonError(ev.Err, ev.Src),
+	}
+	switch ev.Kind {
+	case build.EVT_BUILD, build.EVT_RENDER, build.EVT_SKIP, build.EVT_ERROR:
+		je.DocType = ev.DocType.String()
+	}
+	if cl.bShowVars && (ev.Kind == build.EVT_BUILD) && (ev.Doc != nil) {
+		je.Vars = ev.Doc.Vars
+	}
+	cl.write(je)
+}
+
+func (cl *cliLogger) Summary(bs build.BuildStats) {
+
+	if cl.enc == nil {
+		fmt.Println(bs)
+		return
+	}
+
+	cl.write(jsonEvent{
+		Phase:      "summary",
+		DurationMs: durationMs(bs.Elapsed),
+		jsonSummary: &jsonSummary{
+			Rendered: bs.Rendered,
+			Copied:   bs.Copied,
+			Skipped:  bs.Skipped,
+			Warnings: bs.Warnings,
+			Errors:   bs.Errors,
+			IsOk:     bs.Errors == 0,
+		},
+	})
+}
+
+func (cl *cliLogger) Fatal(err error) {
+
+	if err == nil {
+		return
+	}
+	if cl.enc == nil {
+		ErrRpt(err, cl.bIsTty)
+		return
+	}
+	cl.write(jsonEvent{Phase: "fatal", Error: toJsonError(err, "")})
+}
+
+/*
+Reports progress outside of the build (i.e. golden checks, watch server).
+*/
+func (cl *cliLogger) Info(msg string) {
+	if cl.enc == nil {
+		fmt.Println(msg)
+		return
+	}
+	cl.write(jsonEvent{Phase: "info", Msg: msg})
+}
+
+/*
+Reports a non-fatal error outside of the build.
+*/
+func (cl *cliLogger) Error(err error) {
+	if err == nil {
+		return
+	}
+	if cl.enc == nil {
+		ErrRpt(err, cl.bIsTty)
+		return
+	}
+	cl.write(jsonEvent{Phase: "error", Error: toJsonError(err, "")})
+}
@@ -0,0 +1,510 @@
+package scss
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Limits `@include` nesting.
+const MAX_INCLUDE_DEPTH = 100
+
+type compiler struct {
+	opt      Options
+	mSrc     map[string]string // sources by path, for error excerpts
+	sImports []string          // import stack, for cycle detection
+	sMixins  []string          // `@include` stack, for cycle detection
+}
+
+type contentBlock struct {
+	body    []stmt
+	env     *scope
+	sMixins []string // `@include` stack where the block was passed
+}
+
+/*
+Compilation state for a block of statements.
+*/
+type context struct {
+	env      *scope
+	sels     []string      // enclosing rule's selectors (nil = none)
+	rule     *node         // receives declarations (nil = none allowed)
+	out      *[]*node      // receives nested rules
+	groupOut *[]*node      // receives bubbled `@media` blocks, etc.
+	media    string        // enclosing `@media` query
+	content  *contentBlock // `@content` of the current mixin
+}
+
+func (c *compiler) errAt(ps pos, err error) error {
+	var se *Error
+	if errors.As(err, &se) {
+		return se
+	}
+	return newError(ps, c.mSrc[ps.file], err.Error())
+}
+
+// At-rules whose blocks hold declarations.
+var declAtRules = map[string]bool{
+	"font-face": true, "page": true, "counter-style": true,
+	"font-feature-values": true, "property": true, "viewport": true,
+}
+
+// At-rules whose blocks hold unnested rules (i.e. `from` & `to`).
+func isKeyframes(name string) bool {
+	return strings.HasSuffix(name, "keyframes")
+}
+
+func (c *compiler) block(stmts []stmt, ctx context) error {
+	for _, st := range stmts {
+		if err := c.stmt(st, ctx); err != nil {
+			return c.errAt(st.pos, err)
+		}
+	}
+	return nil
+}
+
+func (c *compiler) stmt(st stmt, ctx context) error {
+
+	switch st.kind {
+	case stComment:
+		if ctx.rule != nil {
+			ctx.rule.decls = append(ctx.rule.decls, st.text)
+		} else {
+			*ctx.out = append(*ctx.out, &node{raw: st.text})
+		}
+		return nil
+
+	case stVar:
+		text := st.text
+		bDefault, bGlobal := false, false
+		for {
+			t := strings.TrimSpace(text)
+			switch {
+			case strings.HasSuffix(t, "!default"):
+				bDefault, text = true, strings.TrimSuffix(t, "!default")
+				continue
+			case strings.HasSuffix(t, "!global"):
+				bGlobal, text = true, strings.TrimSuffix(t, "!global")
+				continue
+			}
+			break
+		}
+		if bDefault {
+			if _, ok := ctx.env.get(st.name); ok {
+				return nil
+			}
+		}
+		v, err := evalExpr(text, ctx.env)
+		if err != nil {
+			return err
+		}
+		v.calc = false
+		ctx.env.set(st.name, v, bGlobal)
+		return nil
+
+	case stDecl:
+		if ctx.rule == nil {
+			return fmt.Errorf("declaration `%s` outside of a rule", st.name)
+		}
+		prop, err := interpolate(st.name, ctx.env)
+		if err != nil {
+			return err
+		}
+		var val string
+		if strings.HasPrefix(prop, "--") {
+			// custom properties are CSS, except for interpolation
+			val, err = interpolate(st.text, ctx.env)
+		} else {
+			var v value
+			v, err = evalExpr(st.text, ctx.env)
+			val = v.String()
+		}
+		if err != nil {
+			return err
+		}
+		ctx.rule.decls = append(ctx.rule.decls, prop+": "+val+";")
+		return nil
+
+	case stRule:
+		return c.rule(st, ctx)
+	}
+	return c.atRule(st, ctx)
+}
+
+/*
+Splits s on commas outside of parentheses, brackets, & strings.
+*/
+func splitTop(s string) []string {
+	var ret []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"') || (c == '\''):
+			quote = c
+		case (c == '(') || (c == '['):
+			depth++
+		case (c == ')') || (c == ']'):
+			depth--
+		case (c == ',') && (depth == 0):
+			ret = append(ret, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ret, strings.TrimSpace(s[start:]))
+}
+
+var rxSpaces = regexp.MustCompile(`\s+`)
+
+/*
+Resolves nested selector list `raw` against parent selectors.
+*/
+func resolveSels(parents []string, raw string) ([]string, error) {
+	var ret []string
+	for _, sel := range splitTop(rxSpaces.ReplaceAllString(raw, " ")) {
+		if len(sel) == 0 {
+			return nil, fmt.Errorf("empty selector in `%s`", raw)
+		}
+		if len(parents) == 0 {
+			if strings.Contains(sel, "&") {
+				return nil, fmt.Errorf("top-level selector `%s` can't contain `&`", sel)
+			}
+			ret = append(ret, sel)
+			continue
+		}
+		for _, p := range parents {
+			if strings.Contains(sel, "&") {
+				ret = append(ret, strings.ReplaceAll(sel, "&", p))
+			} else {
+				ret = append(ret, p+" "+sel)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (c *compiler) rule(st stmt, ctx context) error {
+
+	raw, err := interpolate(st.text, ctx.env)
+	if err != nil {
+		return err
+	}
+	sels, err := resolveSels(ctx.sels, raw)
+	if err != nil {
+		return err
+	}
+
+	n := &node{sels: sels}
+	*ctx.out = append(*ctx.out, n)
+	ctx.sels = sels
+	ctx.rule = n
+	ctx.env = newScope(ctx.env)
+	return c.block(st.children, ctx)
+}
+
+/*
+Conditional group (`@media`, `@supports`, etc.), bubbled out of rules.
+*/
+func (c *compiler) group(st stmt, ctx context, params string) error {
+
+	at := "@" + st.name
+	if st.name == "media" {
+		if len(ctx.media) > 0 {
+			params = ctx.media + " and " + params
+		}
+		ctx.media = params
+	} else {
+		ctx.media = ""
+	}
+	if len(params) > 0 {
+		at += " " + params
+	}
+
+	g := &node{at: at}
+	*ctx.groupOut = append(*ctx.groupOut, g)
+	ctx.out = &g.children
+	if st.name != "media" {
+		ctx.groupOut = &g.children
+	}
+
+	// declarations directly inside apply to the enclosing rule
+	ctx.rule = nil
+	if len(ctx.sels) > 0 {
+		ctx.rule = &node{sels: ctx.sels}
+		g.children = append(g.children, ctx.rule)
+	}
+	ctx.env = newScope(ctx.env)
+	return c.block(st.children, ctx)
+}
+
+func (c *compiler) atRule(st stmt, ctx context) error {
+
+	switch st.name {
+	case "mixin":
+		name, params := splitCall(st.text)
+		if len(name) == 0 {
+			return errors.New("expected mixin name")
+		}
+		mSeen := make(map[string]bool)
+		for _, p := range params {
+			pName, _, ok := mixinParam(p)
+			if !ok {
+				return fmt.Errorf("invalid parameter `%s` for mixin `%s`", p, name)
+			}
+			if mSeen[pName] {
+				return fmt.Errorf("duplicate parameter `$%s` for mixin `%s`", pName, name)
+			}
+			mSeen[pName] = true
+		}
+		ctx.env.mixins[name] = mixin{params: params, body: st.children, env: ctx.env}
+		return nil
+
+	case "include":
+		return c.include(st, ctx)
+
+	case "content":
+		if ctx.content == nil {
+			return nil
+		}
+		cctx := ctx
+		cctx.env = newScope(ctx.content.env)
+		cctx.content = nil
+
+		// the block runs where it was written, not inside the mixin
+		sSave := c.sMixins
+		c.sMixins = ctx.content.sMixins
+		err := c.block(ctx.content.body, cctx)
+		c.sMixins = sSave
+		return err
+
+	case "import":
+		return c.imports(st, ctx)
+
+	case "error", "warn", "debug":
+		v, err := evalExpr(st.text, ctx.env)
+		if err != nil {
+			return err
+		}
+		if st.name == "error" {
+			return errors.New(v.unquoted())
+		}
+		if c.opt.Warn != nil {
+			c.opt.Warn(newError(st.pos, c.mSrc[st.pos.file], fmt.Sprintf("@%s: %s", st.name, v.unquoted())))
+		}
+		return nil
+
+	case "if", "else", "each", "for", "while", "function", "return",
+		"extend", "use", "forward", "at-root":
+		return fmt.Errorf("`@%s` is not supported", st.name)
+	}
+
+	params, err := interpolate(st.text, ctx.env)
+	if err != nil {
+		return err
+	}
+	params = rxSpaces.ReplaceAllString(params, " ")
+
+	switch {
+	// i.e. `@charset`, `@namespace`
+	case !st.hasBlock:
+		line := "@" + st.name
+		if len(params) > 0 {
+			line += " " + params
+		}
+		if ctx.rule != nil {
+			ctx.rule.decls = append(ctx.rule.decls, line+";")
+		} else {
+			*ctx.out = append(*ctx.out, &node{raw: line + ";"})
+		}
+		return nil
+
+	case declAtRules[st.name]:
+		sel := "@" + st.name
+		if len(params) > 0 {
+			sel += " " + params
+		}
+		n := &node{sels: []string{sel}}
+		*ctx.groupOut = append(*ctx.groupOut, n)
+		ctx.sels, ctx.rule, ctx.out = nil, n, ctx.groupOut
+		ctx.env = newScope(ctx.env)
+		return c.block(st.children, ctx)
+
+	case isKeyframes(st.name):
+		k := &node{at: "@" + st.name + " " + params}
+		*ctx.groupOut = append(*ctx.groupOut, k)
+		ctx.sels, ctx.rule = nil, nil
+		ctx.out, ctx.groupOut = &k.children, &k.children
+		ctx.env = newScope(ctx.env)
+		return c.block(st.children, ctx)
+	}
+	return c.group(st, ctx, params)
+}
+
+/*
+`name(a, b)` -> name, [a, b]
+*/
+func splitCall(s string) (string, []string) {
+	ix := strings.IndexByte(s, '(')
+	if ix < 0 {
+		return strings.TrimSpace(s), nil
+	}
+	name := strings.TrimSpace(s[:ix])
+	inner := strings.TrimSpace(s[ix+1:])
+	inner = strings.TrimSpace(strings.TrimSuffix(inner, ")"))
+	if len(inner) == 0 {
+		return name, nil
+	}
+	return name, splitTop(inner)
+}
+
+var rxKeywordArg = regexp.MustCompile(`^\$([\w-]+)\s*:\s*(.*)$`)
+var rxParamName = regexp.MustCompile(`^\$([\w-]+)$`)
+
+/*
+Mixin parameter `$name` or `$name: default`.
+*/
+func mixinParam(p string) (name, def string, ok bool) {
+	if sm := rxKeywordArg.FindStringSubmatch(p); sm != nil {
+		return sm[1], sm[2], true
+	}
+	if sm := rxParamName.FindStringSubmatch(p); sm != nil {
+		return sm[1], "", true
+	}
+	return "", "", false
+}
+
+func (c *compiler) include(st stmt, ctx context) error {
+
+	name, sArgs := splitCall(st.text)
+	m, ok := ctx.env.getMixin(name)
+	if !ok {
+		return fmt.Errorf("undefined mixin `%s`", name)
+	}
+
+	// w/o control flow, a mixin that includes itself never stops
+	sChain := append(append([]string{}, c.sMixins...), name)
+	for _, prev := range c.sMixins {
+		if prev == name {
+			return fmt.Errorf("mixin cycle: %s", strings.Join(sChain, " -> "))
+		}
+	}
+	if len(c.sMixins) >= MAX_INCLUDE_DEPTH {
+		return fmt.Errorf("mixins nested too deeply (max %d): %s", MAX_INCLUDE_DEPTH, strings.Join(sChain, " -> "))
+	}
+
+	// args, evaluated in the caller's scope
+	var positional []value
+	mKeyword := make(map[string]value)
+	for _, a := range sArgs {
+		expr := a
+		kw := ""
+		if sm := rxKeywordArg.FindStringSubmatch(a); sm != nil {
+			kw, expr = sm[1], sm[2]
+		}
+		v, err := evalExpr(expr, ctx.env)
+		if err != nil {
+			return err
+		}
+		v.calc = false
+		if len(kw) > 0 {
+			mKeyword[kw] = v
+		} else if len(mKeyword) > 0 {
+			return fmt.Errorf("positional argument after keyword argument in `@include %s`", name)
+		} else {
+			positional = append(positional, v)
+		}
+	}
+	if len(positional) > len(m.params) {
+		return fmt.Errorf("mixin `%s` takes %d argument(s), got %d", name, len(m.params), len(positional))
+	}
+
+	// bind params, in the mixin's defining scope
+	env := newScope(m.env)
+	for i, p := range m.params {
+		pName, pDefault, _ := mixinParam(p)
+
+		v, ok := mKeyword[pName]
+		delete(mKeyword, pName)
+		switch {
+		case i < len(positional):
+			v = positional[i]
+		case ok:
+		case len(pDefault) > 0:
+			var err error
+			if v, err = evalExpr(pDefault, env); err != nil {
+				return err
+			}
+			v.calc = false
+		default:
+			return fmt.Errorf("missing argument `$%s` for mixin `%s`", pName, name)
+		}
+		env.vars[pName] = v
+	}
+	for kw := range mKeyword {
+		return fmt.Errorf("mixin `%s` has no parameter `$%s`", name, kw)
+	}
+
+	mctx := ctx
+	mctx.env = env
+	mctx.content = nil
+	if st.hasBlock {
+		mctx.content = &contentBlock{body: st.children, env: ctx.env, sMixins: c.sMixins}
+	}
+	sSave := c.sMixins
+	c.sMixins = sChain
+	err := c.block(m.body, mctx)
+	c.sMixins = sSave
+	return err
+}
+
+/*
+True for imports that stay CSS `@import`s.
+*/
+func isCSSImport(s string) bool {
+	u := strings.Trim(s, `"'`)
+	return strings.HasPrefix(s, "url(") || strings.HasSuffix(u, ".css") ||
+		strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") ||
+		strings.HasPrefix(u, "//") || (u == s) // unquoted, or w/ media queries
+}
+
+func (c *compiler) imports(st stmt, ctx context) error {
+
+	for _, name := range splitTop(st.text) {
+		if isCSSImport(name) {
+			*ctx.out = append(*ctx.out, &node{raw: "@import " + name + ";"})
+			continue
+		}
+		name = strings.Trim(name, `"'`)
+		if c.opt.Import == nil {
+			return fmt.Errorf("can't import `%s`", name)
+		}
+		path, src, err := c.opt.Import(name, st.pos.file)
+		if err != nil {
+			return err
+		}
+		for _, imp := range append(c.sImports, st.pos.file) {
+			if imp == path {
+				return fmt.Errorf("import cycle: `%s` imports itself", path)
+			}
+		}
+
+		c.mSrc[path] = string(src)
+		stmts, err := parse(string(src), path)
+		if err != nil {
+			return err
+		}
+		c.sImports = append(c.sImports, st.pos.file)
+		err = c.block(stmts, ctx)
+		c.sImports = c.sImports[:len(c.sImports)-1]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
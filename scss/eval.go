@@ -0,0 +1,766 @@
+package scss
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type valKind int
+
+const (
+	vStr  valKind = iota // identifiers, colors, & quoted strings
+	vNum                 // numbers, w/ optional unit
+	vList                // space or comma separated
+)
+
+type value struct {
+	kind   valKind
+	str    string // vStr: text, including quotes (if any)
+	num    float64
+	unit   string
+	items  []value
+	sep    string // vList: " " or ", "
+	calc   bool   // from a variable or arithmetic (see `/`)
+	opaque bool   // colors & function calls, which don't concatenate
+}
+
+func (v value) String() string {
+	switch v.kind {
+	case vNum:
+		return fmtNum(v.num) + v.unit
+	case vList:
+		sItems := make([]string, len(v.items))
+		for i := range v.items {
+			sItems[i] = v.items[i].String()
+		}
+		return strings.Join(sItems, v.sep)
+	}
+	return v.str
+}
+
+/*
+Text of v, without surrounding quotes (for interpolation).
+*/
+func (v value) unquoted() string {
+	s := v.String()
+	if (v.kind == vStr) && (len(s) >= 2) && ((s[0] == '"') || (s[0] == '\'')) && (s[len(s)-1] == s[0]) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func fmtNum(f float64) string {
+	f = math.Round(f*1e5) / 1e5
+	if f == 0 {
+		f = 0 // no `-0`
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type tokKind int
+
+const (
+	tSpace tokKind = iota
+	tNum
+	tStr   // identifier, color, quoted string, or raw function (i.e. `url(...)`)
+	tVar   // `$name`
+	tFunc  // `name(`
+	tOp    // + - * /
+	tComma // ,
+	tLParen
+	tRParen
+)
+
+type token struct {
+	kind tokKind
+	text string // tStr, tVar & tFunc names, tOp
+	num  float64
+	unit string
+}
+
+func isNameByte(c byte) bool {
+	return (c == '-') || (c == '_') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') || (c >= 0x80)
+}
+
+func isDigit(c byte) bool {
+	return (c >= '0') && (c <= '9')
+}
+
+// Functions whose arguments are passed through as-is (after `$var` substitution).
+var rawFuncs = map[string]bool{
+	"url": true, "calc": true, "var": true, "env": true, "attr": true,
+	"min": true, "max": true, "clamp": true, "format": true, "local": true,
+	"element": true, "expression": true,
+}
+
+/*
+Scans the matching `)` for the `(` at s[i], skipping strings.
+Returns the index after `)`.
+*/
+func matchParen(s string, i int) (int, error) {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\'':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return 0, fmt.Errorf("unterminated string")
+			}
+			i += j + 1
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("expected `)`")
+}
+
+func tokenize(s string) ([]token, error) {
+
+	var ret []token
+	fnPrevIsOperand := func() bool {
+		if len(ret) == 0 {
+			return false
+		}
+		switch ret[len(ret)-1].kind {
+		case tNum, tStr, tVar, tRParen:
+			return true
+		}
+		return false
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case strings.IndexByte(" \t\r\n\f", c) >= 0:
+			for (i < len(s)) && (strings.IndexByte(" \t\r\n\f", s[i]) >= 0) {
+				i++
+			}
+			ret = append(ret, token{kind: tSpace})
+
+		case (c == '"') || (c == '\''):
+			j := i + 1
+			for (j < len(s)) && (s[j] != c) {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			ret = append(ret, token{kind: tStr, text: s[i : j+1]})
+			i = j + 1
+
+		// numbers, including a sign when not a binary operator
+		case isDigit(c) ||
+			((c == '.') && (i+1 < len(s)) && isDigit(s[i+1])) ||
+			(((c == '-') || (c == '+')) && !fnPrevIsOperand() && (i+1 < len(s)) &&
+				(isDigit(s[i+1]) || ((s[i+1] == '.') && (i+2 < len(s)) && isDigit(s[i+2])))):
+			j := i + 1
+			for (j < len(s)) && (isDigit(s[j]) || (s[j] == '.')) {
+				j++
+			}
+			f, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number `%s`", s[i:j])
+			}
+			k := j
+			if (k < len(s)) && (s[k] == '%') {
+				k++
+			} else {
+				for (k < len(s)) && isNameByte(s[k]) && !((s[k] == '-') && ((k+1 >= len(s)) || !isNameByte(s[k+1]))) {
+					k++
+				}
+			}
+			ret = append(ret, token{kind: tNum, num: f, unit: s[j:k]})
+			i = k
+
+		case c == '$':
+			j := i + 1
+			for (j < len(s)) && isNameByte(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("expected variable name after `$`")
+			}
+			ret = append(ret, token{kind: tVar, text: s[i+1 : j]})
+			i = j
+
+		case c == ',':
+			ret = append(ret, token{kind: tComma})
+			i++
+		case c == '(':
+			ret = append(ret, token{kind: tLParen})
+			i++
+		case c == ')':
+			ret = append(ret, token{kind: tRParen})
+			i++
+		case strings.IndexByte("+-*/", c) >= 0:
+			// `-name` is an identifier
+			if (c == '-') && (i+1 < len(s)) && (isNameByte(s[i+1]) && !isDigit(s[i+1])) && !fnPrevIsOperand() {
+				j := i + 1
+				for (j < len(s)) && isNameByte(s[j]) {
+					j++
+				}
+				ret = append(ret, token{kind: tStr, text: s[i:j]})
+				i = j
+				continue
+			}
+			ret = append(ret, token{kind: tOp, text: string(c)})
+			i++
+
+		default:
+			// identifiers, colors, `!important`, etc.
+			j := i + 1
+			for (j < len(s)) && (isNameByte(s[j]) || (s[j] == '.') || (s[j] == '#') || (s[j] == '%') || (s[j] == ':')) {
+				j++
+			}
+			name := s[i:j]
+			if (j < len(s)) && (s[j] == '(') && isNameByte(c) {
+				if rawFuncs[strings.ToLower(name)] {
+					k, err := matchParen(s, j)
+					if err != nil {
+						return nil, err
+					}
+					ret = append(ret, token{kind: tStr, text: s[i:k]})
+					i = k
+					continue
+				}
+				ret = append(ret, token{kind: tFunc, text: name})
+				i = j + 1
+				continue
+			}
+			ret = append(ret, token{kind: tStr, text: name})
+			i = j
+		}
+	}
+	return ret, nil
+}
+
+/*
+Recursive descent evaluator over a token list.
+*/
+type evaluator struct {
+	toks   []token
+	ix     int
+	env    *scope
+	parens int
+}
+
+func (e *evaluator) cur() (token, bool) {
+	if e.ix < len(e.toks) {
+		return e.toks[e.ix], true
+	}
+	return token{}, false
+}
+
+func (e *evaluator) isKind(k tokKind) bool {
+	t, ok := e.cur()
+	return ok && (t.kind == k)
+}
+
+func (e *evaluator) skipSpace() bool {
+	bSkipped := false
+	for e.isKind(tSpace) {
+		e.ix++
+		bSkipped = true
+	}
+	return bSkipped
+}
+
+// True at the end of a list item (end, `,`, or `)`).
+func (e *evaluator) atEnd() bool {
+	t, ok := e.cur()
+	return !ok || (t.kind == tComma) || (t.kind == tRParen)
+}
+
+func (e *evaluator) commaList() (value, error) {
+	e.skipSpace()
+	var items []value
+	for {
+		v, err := e.spaceList()
+		if err != nil {
+			return value{}, err
+		}
+		items = append(items, v)
+		if !e.isKind(tComma) {
+			break
+		}
+		e.ix++
+		e.skipSpace()
+		if e.atEnd() {
+			break // trailing comma
+		}
+	}
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return value{kind: vList, items: items, sep: ", "}, nil
+}
+
+func (e *evaluator) spaceList() (value, error) {
+	var items []value
+	for {
+		e.skipSpace()
+		if e.atEnd() {
+			break
+		}
+		v, err := e.additive()
+		if err != nil {
+			return value{}, err
+		}
+		items = append(items, v)
+	}
+	switch len(items) {
+	case 0:
+		return value{kind: vStr}, nil
+	case 1:
+		return items[0], nil
+	}
+	return value{kind: vList, items: items, sep: " "}, nil
+}
+
+/*
+Next binary operator among `ops`, when present (consumed along with its
+surrounding whitespace).  `+` & `-` need matching whitespace on both sides,
+so `1px -2px` stays a list.
+*/
+func (e *evaluator) binOp(ops string, bNeedSpace bool) (op string, bSpaced bool, ok bool) {
+	save := e.ix
+	bBefore := e.skipSpace()
+	t, ok := e.cur()
+	if !ok || (t.kind != tOp) || !strings.Contains(ops, t.text) {
+		e.ix = save
+		return "", false, false
+	}
+	e.ix++
+	bAfter := e.isKind(tSpace)
+	if bNeedSpace && (bBefore != bAfter) {
+		e.ix = save
+		return "", false, false
+	}
+	e.skipSpace()
+	return t.text, bBefore || bAfter, true
+}
+
+func (e *evaluator) additive() (value, error) {
+	left, err := e.multiplicative()
+	if err != nil {
+		return value{}, err
+	}
+	for {
+		op, _, ok := e.binOp("+-", true)
+		if !ok {
+			return left, nil
+		}
+		right, err := e.multiplicative()
+		if err != nil {
+			return value{}, err
+		}
+		if left, err = arith(op, left, right); err != nil {
+			return value{}, err
+		}
+	}
+}
+
+func (e *evaluator) multiplicative() (value, error) {
+	left, err := e.unary()
+	if err != nil {
+		return value{}, err
+	}
+	for {
+		op, bSpaced, ok := e.binOp("*/", false)
+		if !ok {
+			return left, nil
+		}
+		right, err := e.unary()
+		if err != nil {
+			return value{}, err
+		}
+
+		// plain `a/b` is CSS (i.e. `font: 12px/1.5`), not division
+		if (op == "/") && (e.parens == 0) && !left.calc && !right.calc {
+			sep := "/"
+			if bSpaced {
+				sep = " / "
+			}
+			left = value{kind: vStr, str: left.String() + sep + right.String()}
+			continue
+		}
+		if left, err = arith(op, left, right); err != nil {
+			return value{}, err
+		}
+	}
+}
+
+func (e *evaluator) unary() (value, error) {
+	t, ok := e.cur()
+	if ok && (t.kind == tOp) && ((t.text == "-") || (t.text == "+")) {
+		e.ix++
+		v, err := e.primary()
+		if err != nil {
+			return value{}, err
+		}
+		if v.kind == vNum {
+			if t.text == "-" {
+				v.num = -v.num
+			}
+			return v, nil
+		}
+		return value{kind: vStr, str: t.text + v.String()}, nil
+	}
+	return e.primary()
+}
+
+func (e *evaluator) primary() (value, error) {
+
+	t, ok := e.cur()
+	if !ok {
+		return value{}, fmt.Errorf("expected expression")
+	}
+	e.ix++
+
+	switch t.kind {
+	case tNum:
+		return value{kind: vNum, num: t.num, unit: t.unit}, nil
+
+	case tStr:
+		// `$vars` inside raw functions, i.e. `calc(100% - $gap)`
+		if strings.Contains(t.text, "$") && strings.HasSuffix(t.text, ")") {
+			s, err := substVars(t.text, e.env)
+			return value{kind: vStr, str: s}, err
+		}
+		return value{kind: vStr, str: t.text, opaque: strings.HasPrefix(t.text, "#")}, nil
+
+	case tVar:
+		v, ok := e.env.get(t.text)
+		if !ok {
+			return value{}, fmt.Errorf("undefined variable `$%s`", t.text)
+		}
+		v.calc = true
+		return v, nil
+
+	case tLParen:
+		e.parens++
+		v, err := e.commaList()
+		e.parens--
+		if err != nil {
+			return value{}, err
+		}
+		if !e.isKind(tRParen) {
+			return value{}, fmt.Errorf("expected `)`")
+		}
+		e.ix++
+		v.calc = true
+		return v, nil
+
+	case tFunc:
+		// NOTE: `/` stays CSS in args, i.e. `rgb(0 0 0 / 50%)`
+		var args []value
+		for {
+			e.skipSpace()
+			if e.isKind(tRParen) {
+				break
+			}
+			v, err := e.spaceList()
+			if err != nil {
+				return value{}, err
+			}
+			args = append(args, v)
+			if !e.isKind(tComma) {
+				break
+			}
+			e.ix++
+		}
+		if !e.isKind(tRParen) {
+			return value{}, fmt.Errorf("expected `)` after `%s(` arguments", t.text)
+		}
+		e.ix++
+		v, err := callFunc(t.text, args)
+		if v.kind == vStr {
+			v.opaque = true
+		}
+		return v, err
+	}
+	return value{}, fmt.Errorf("unexpected `%s`", tokText(t))
+}
+
+func tokText(t token) string {
+	switch t.kind {
+	case tComma:
+		return ","
+	case tLParen:
+		return "("
+	case tRParen:
+		return ")"
+	}
+	return t.text
+}
+
+func arith(op string, a, b value) (value, error) {
+
+	if (a.kind != vNum) || (b.kind != vNum) {
+		// string concatenation, i.e. `"a" + b`
+		if (op == "+") && (a.kind == vStr) && !a.opaque && (b.kind != vList) {
+			s := a.unquoted() + b.unquoted()
+			if (len(a.str) > 0) && ((a.str[0] == '"') || (a.str[0] == '\'')) {
+				s = string(a.str[0]) + s + string(a.str[0])
+			}
+			return value{kind: vStr, str: s, calc: true}, nil
+		}
+		return value{}, fmt.Errorf("undefined operation `%s %s %s`", a, op, b)
+	}
+
+	ret := value{kind: vNum, calc: true, unit: a.unit}
+	if len(ret.unit) == 0 {
+		ret.unit = b.unit
+	}
+	bUnitsDiffer := (len(a.unit) > 0) && (len(b.unit) > 0) && (a.unit != b.unit)
+
+	switch op {
+	case "+", "-":
+		if bUnitsDiffer {
+			return value{}, fmt.Errorf("incompatible units `%s` and `%s`", a.unit, b.unit)
+		}
+		if op == "+" {
+			ret.num = a.num + b.num
+		} else {
+			ret.num = a.num - b.num
+		}
+	case "*":
+		if (len(a.unit) > 0) && (len(b.unit) > 0) {
+			return value{}, fmt.Errorf("`%s * %s` isn't a valid CSS value", a, b)
+		}
+		ret.num = a.num * b.num
+	case "/":
+		if b.num == 0 {
+			return value{}, fmt.Errorf("division by zero")
+		}
+		ret.num = a.num / b.num
+		switch {
+		case bUnitsDiffer:
+			return value{}, fmt.Errorf("incompatible units `%s` and `%s`", a.unit, b.unit)
+		case len(b.unit) > 0:
+			if len(a.unit) == 0 {
+				return value{}, fmt.Errorf("`%s / %s` isn't a valid CSS value", a, b)
+			}
+			ret.unit = "" // same units cancel
+		}
+	}
+	return ret, nil
+}
+
+/*
+Replaces `$name` with variable values in raw text.
+*/
+func substVars(s string, env *scope) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		j := i + 1
+		for (j < len(s)) && isNameByte(s[j]) {
+			j++
+		}
+		v, ok := env.get(s[i+1 : j])
+		if !ok {
+			return "", fmt.Errorf("undefined variable `%s`", s[i:j])
+		}
+		sb.WriteString(v.String())
+		i = j - 1
+	}
+	return sb.String(), nil
+}
+
+/*
+Expands `#{expr}` interpolation in s.
+*/
+func interpolate(s string, env *scope) (string, error) {
+	if !strings.Contains(s, "#{") {
+		return s, nil
+	}
+	var sb strings.Builder
+	for {
+		ix := strings.Index(s, "#{")
+		if ix < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		sb.WriteString(s[:ix])
+		end := strings.IndexByte(s[ix:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated `#{`")
+		}
+		v, err := evalExpr(s[ix+2:ix+end], env)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(v.unquoted())
+		s = s[ix+end+1:]
+	}
+}
+
+/*
+Evaluates SassScript expression `s` (after interpolation).
+*/
+func evalExpr(s string, env *scope) (value, error) {
+	s, err := interpolate(s, env)
+	if err != nil {
+		return value{}, err
+	}
+	toks, err := tokenize(s)
+	if err != nil {
+		return value{}, err
+	}
+	e := &evaluator{toks: toks, env: env}
+	v, err := e.commaList()
+	if err != nil {
+		return value{}, err
+	}
+	if t, ok := e.cur(); ok {
+		return value{}, fmt.Errorf("unexpected `%s`", tokText(t))
+	}
+	return v, nil
+}
+
+/*
+Built-in functions.  Others are output as CSS function calls.
+*/
+func callFunc(name string, args []value) (value, error) {
+
+	fnCSS := func() value {
+		sArgs := make([]string, len(args))
+		for i := range args {
+			sArgs[i] = args[i].String()
+		}
+		return value{kind: vStr, str: name + "(" + strings.Join(sArgs, ", ") + ")"}
+	}
+
+	switch strings.ToLower(name) {
+	case "rgba", "rgb":
+		// rgba(<color>, <alpha>)
+		if len(args) != 2 {
+			return fnCSS(), nil
+		}
+		r, g, b, ok := parseHex(args[0].String())
+		if !ok || (args[1].kind != vNum) {
+			return fnCSS(), nil
+		}
+		return value{kind: vStr, str: fmt.Sprintf("rgba(%d, %d, %d, %s)", r, g, b, fmtNum(args[1].num))}, nil
+
+	case "lighten", "darken":
+		if len(args) != 2 {
+			return value{}, fmt.Errorf("%s(): expected 2 arguments", name)
+		}
+		r, g, b, ok := parseHex(args[0].String())
+		if !ok {
+			return value{}, fmt.Errorf("%s(): `%s` is not a hex color", name, args[0])
+		}
+		if (args[1].kind != vNum) || ((args[1].unit != "%") && (len(args[1].unit) > 0)) {
+			return value{}, fmt.Errorf("%s(): `%s` is not a percentage", name, args[1])
+		}
+		amt := args[1].num / 100
+		if strings.EqualFold(name, "darken") {
+			amt = -amt
+		}
+		h, s, l := rgb2hsl(r, g, b)
+		l = math.Max(0, math.Min(1, l+amt))
+		r, g, b = hsl2rgb(h, s, l)
+		return value{kind: vStr, str: fmt.Sprintf("#%02x%02x%02x", r, g, b)}, nil
+
+	case "percentage":
+		if (len(args) != 1) || (args[0].kind != vNum) || (len(args[0].unit) > 0) {
+			return value{}, fmt.Errorf("percentage(): expected a unitless number")
+		}
+		return value{kind: vNum, num: args[0].num * 100, unit: "%", calc: true}, nil
+	}
+	return fnCSS(), nil
+}
+
+func parseHex(s string) (r, g, b int, ok bool) {
+	if !strings.HasPrefix(s, "#") {
+		return
+	}
+	s = s[1:]
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return
+	}
+	return int(n >> 16), int((n >> 8) & 0xff), int(n & 0xff), true
+}
+
+func rgb2hsl(r, g, b int) (h, s, l float64) {
+	fr, fg, fb := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(fr, math.Max(fg, fb))
+	min := math.Min(fr, math.Min(fg, fb))
+	l = (max + min) / 2
+	if max == min {
+		return 0, 0, l
+	}
+	d := max - min
+	if l > 0.5 {
+		s = d / (2 - max - min)
+	} else {
+		s = d / (max + min)
+	}
+	switch max {
+	case fr:
+		h = (fg - fb) / d
+		if fg < fb {
+			h += 6
+		}
+	case fg:
+		h = (fb-fr)/d + 2
+	default:
+		h = (fr-fg)/d + 4
+	}
+	return h / 6, s, l
+}
+
+func hsl2rgb(h, s, l float64) (r, g, b int) {
+	if s == 0 {
+		v := int(math.Round(l * 255))
+		return v, v, v
+	}
+	fnHue := func(p, q, t float64) float64 {
+		if t < 0 {
+			t++
+		}
+		if t > 1 {
+			t--
+		}
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 0.5:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	var q float64
+	if l < 0.5 {
+		q = l * (1 + s)
+	} else {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	fnByte := func(f float64) int { return int(math.Round(f * 255)) }
+	return fnByte(fnHue(p, q, h+1.0/3)), fnByte(fnHue(p, q, h)), fnByte(fnHue(p, q, h-1.0/3))
+}
@@ -0,0 +1,321 @@
+package scss
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type pos struct {
+	file      string
+	line, col int
+}
+
+type stmtKind int
+
+const (
+	stDecl    stmtKind = iota // `prop: value`
+	stVar                     // `$name: value`
+	stRule                    // `selector { ... }`
+	stAt                      // `@name params [{ ... }]`
+	stComment                 // block comment
+)
+
+type stmt struct {
+	kind     stmtKind
+	pos      pos
+	name     string // property, variable, or at-rule name (w/o `$` or `@`)
+	text     string // selector, value, at-rule params, or comment
+	children []stmt
+	hasBlock bool
+}
+
+type parser struct {
+	src       string
+	file      string
+	off       int
+	line, col int
+}
+
+func parse(src, file string) ([]stmt, error) {
+	p := &parser{src: src, file: file, line: 1, col: 1}
+	return p.block(nil)
+}
+
+func (p *parser) pos() pos {
+	return pos{file: p.file, line: p.line, col: p.col}
+}
+
+func (p *parser) errAt(ps pos, format string, args ...interface{}) error {
+	return newError(ps, p.src, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.off >= len(p.src)
+}
+
+func (p *parser) peek(s string) bool {
+	return strings.HasPrefix(p.src[p.off:], s)
+}
+
+func (p *parser) advance(n int) {
+	for ; (n > 0) && !p.eof(); n-- {
+		if p.src[p.off] == '\n' {
+			p.line++
+			p.col = 1
+		} else {
+			p.col++
+		}
+		p.off++
+	}
+}
+
+/*
+Skips a block comment (returning its text), or a `//` comment.
+*/
+func (p *parser) comment() (text string, ok bool, err error) {
+	switch {
+	case p.peek("//"):
+		for !p.eof() && (p.src[p.off] != '\n') {
+			p.advance(1)
+		}
+		return "", true, nil
+	case p.peek("/*"):
+		ps := p.pos()
+		start := p.off
+		ix := strings.Index(p.src[p.off+2:], "*/")
+		if ix < 0 {
+			return "", false, p.errAt(ps, "unterminated comment")
+		}
+		p.advance(ix + 4)
+		return p.src[start:p.off], true, nil
+	}
+	return "", false, nil
+}
+
+/*
+Parses statements until `}`, or until EOF for the top level (open == nil).
+*/
+func (p *parser) block(open *pos) ([]stmt, error) {
+
+	bTop := (open == nil)
+
+	var ret []stmt
+	for {
+		// whitespace & comments
+		for !p.eof() && strings.IndexByte(" \t\r\n\f", p.src[p.off]) >= 0 {
+			p.advance(1)
+		}
+		ps := p.pos()
+		text, ok, err := p.comment()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if len(text) > 0 {
+				ret = append(ret, stmt{kind: stComment, pos: ps, text: text})
+			}
+			continue
+		}
+
+		if p.eof() {
+			if !bTop {
+				return nil, p.errAt(*open, "unclosed block (expected `}`)")
+			}
+			return ret, nil
+		}
+		switch p.src[p.off] {
+		case '}':
+			if bTop {
+				return nil, p.errAt(ps, "unexpected `}`")
+			}
+			p.advance(1)
+			return ret, nil
+		case ';':
+			p.advance(1)
+			continue
+		}
+
+		chunk, term, err := p.chunk()
+		if err != nil {
+			return nil, err
+		}
+		st, err := p.stmt(ps, chunk, term == '{')
+		if err != nil {
+			return nil, err
+		}
+		if st.hasBlock {
+			if st.children, err = p.block(&ps); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, st)
+	}
+}
+
+/*
+Reads up to the next top-level `{`, `;`, or `}`, skipping strings,
+parentheses, interpolation, and comments.  Consumes `{` & `;`, but not `}`.
+*/
+func (p *parser) chunk() (string, byte, error) {
+
+	var sb strings.Builder
+	ps := p.pos()
+	depth, interp := 0, 0
+	for !p.eof() {
+		c := p.src[p.off]
+		switch {
+		case (c == '"') || (c == '\''):
+			start := p.off
+			p.advance(1)
+			for !p.eof() && (p.src[p.off] != c) {
+				if p.src[p.off] == '\\' {
+					p.advance(1)
+				}
+				if !p.eof() && (p.src[p.off] == '\n') {
+					return "", 0, p.errAt(ps, "unterminated string")
+				}
+				p.advance(1)
+			}
+			if p.eof() {
+				return "", 0, p.errAt(ps, "unterminated string")
+			}
+			p.advance(1)
+			sb.WriteString(p.src[start:p.off])
+			continue
+		case (depth == 0) && (p.peek("//") || p.peek("/*")):
+			if _, _, err := p.comment(); err != nil {
+				return "", 0, err
+			}
+			sb.WriteByte(' ')
+			continue
+		case p.peek("#{"):
+			interp++
+			sb.WriteString("#{")
+			p.advance(2)
+			continue
+		case (c == '}') && (interp > 0):
+			interp--
+		case (c == '(') || (c == '['):
+			depth++
+		case ((c == ')') || (c == ']')) && (depth > 0):
+			depth--
+		case (depth == 0) && (interp == 0) && ((c == '{') || (c == ';') || (c == '}')):
+			if c != '}' {
+				p.advance(1)
+			}
+			return strings.TrimSpace(sb.String()), c, nil
+		}
+		sb.WriteByte(c)
+		p.advance(1)
+	}
+	return strings.TrimSpace(sb.String()), 0, nil
+}
+
+/*
+Index of the first top-level `:` in s (outside of interpolation), or -1.
+*/
+func indexColon(s string) int {
+	interp := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "#{"):
+			interp++
+			i++
+		case (s[i] == '}') && (interp > 0):
+			interp--
+		case (s[i] == ':') && (interp == 0):
+			return i
+		}
+	}
+	return -1
+}
+
+var rxVarName = regexp.MustCompile(`^[\w-]+$`)
+
+func isNameRune(r rune) bool {
+	return (r == '-') || (r == '_') || (r == '$') || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+/*
+Index of the first `:` in s outside of strings, parentheses, brackets, &
+interpolation, or -1.
+*/
+func indexTopColon(s string) int {
+	depth, interp := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"') || (c == '\''):
+			quote = c
+		case strings.HasPrefix(s[i:], "#{"):
+			interp++
+			i++
+		case (c == '}') && (interp > 0):
+			interp--
+		case (c == '(') || (c == '['):
+			depth++
+		case ((c == ')') || (c == ']')) && (depth > 0):
+			depth--
+		case (c == ':') && (depth == 0) && (interp == 0):
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *parser) stmt(ps pos, chunk string, bBlock bool) (stmt, error) {
+
+	st := stmt{pos: ps, hasBlock: bBlock}
+	switch {
+	case strings.HasPrefix(chunk, "@"):
+		st.kind = stAt
+		name := chunk[1:]
+		if ix := strings.IndexAny(name, " \t\r\n(;"); ix >= 0 {
+			st.name, st.text = name[:ix], strings.TrimSpace(name[ix:])
+		} else {
+			st.name = name
+		}
+		if len(st.name) == 0 {
+			return st, p.errAt(ps, "expected at-rule name")
+		}
+	case bBlock:
+		st.kind = stRule
+		st.text = chunk
+	default:
+		ix := indexColon(chunk)
+		if ix < 0 {
+			return st, p.errAt(ps, "expected `:` in declaration `%s`", chunk)
+		}
+		st.name = strings.TrimSpace(chunk[:ix])
+		st.text = strings.TrimSpace(chunk[ix+1:])
+		st.kind = stDecl
+		if strings.HasPrefix(st.name, "$") {
+			st.kind = stVar
+			st.name = st.name[1:]
+			if !rxVarName.MatchString(st.name) {
+				return st, p.errAt(ps, "invalid variable name `$%s`", st.name)
+			}
+		}
+		if len(st.text) == 0 {
+			return st, p.errAt(ps, "expected value for `%s`", chunk[:ix])
+		}
+		// i.e. `b: c <newline> d: e`, which would merge into one value
+		if !strings.HasPrefix(st.name, "--") {
+			if ic := indexTopColon(st.text); ic >= 0 {
+				before := strings.TrimRight(st.text[:ic], " \t\r\n\f")
+				before = strings.TrimSpace(strings.TrimRightFunc(before, isNameRune))
+				return st, p.errAt(ps, "expected `;` after `%s: %s`", strings.TrimSpace(chunk[:ix]), before)
+			}
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,185 @@
+/*
+Package scss compiles a subset of SCSS to CSS, in pure Go.
+
+Supported: variables (`!default` & `!global`), nesting (w/ `&`), mixins
+(w/ default & keyword arguments, and `@content`), `@import` of partials,
+`@media` bubbling, arithmetic, string concatenation, and the `rgba`,
+`lighten`, `darken`, & `percentage` functions.  Control flow (`@if`,
+`@each`, etc.), `@extend`, `@function`, and the module system (`@use`)
+are not.
+*/
+package scss
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Compile error, located within its source file.
+*/
+type Error struct {
+	File string
+	Line int
+	Col  int    // 1-based
+	Msg  string // error, minus location
+	Text string // offending source line
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+func newError(ps pos, src, msg string) *Error {
+	ret := &Error{File: ps.file, Line: ps.line, Col: ps.col, Msg: msg}
+	if sLines := strings.Split(src, "\n"); ps.line <= len(sLines) {
+		ret.Text = strings.TrimRight(sLines[ps.line-1], "\r")
+	}
+	return ret
+}
+
+/*
+Loads `@import`ed stylesheet `name` (as written), imported from file
+`from`.  Returns its path (for error messages), and its contents.
+*/
+type Importer func(name, from string) (path string, src []byte, err error)
+
+type Options struct {
+	Filename string      // of the compiled source, for error messages
+	Import   Importer    // nil = imports are errors
+	Warn     func(error) // `@warn` & `@debug` messages (nil = ignored)
+}
+
+/*
+Compiles SCSS source `src` into CSS, written to dst.
+*/
+func Compile(dst io.Writer, src []byte, opt Options) error {
+
+	c := &compiler{opt: opt, mSrc: map[string]string{opt.Filename: string(src)}}
+	stmts, err := parse(string(src), opt.Filename)
+	if err != nil {
+		return err
+	}
+
+	var root []*node
+	ctx := context{env: newScope(nil), out: &root, groupOut: &root}
+	if err = c.block(stmts, ctx); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	writeNodes(&sb, root, "")
+	_, err = io.WriteString(dst, sb.String())
+	return err
+}
+
+type mixin struct {
+	params []string // `$name` or `$name: default`
+	body   []stmt
+	env    *scope // defining scope
+}
+
+type scope struct {
+	vars   map[string]value
+	mixins map[string]mixin
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]value), mixins: make(map[string]mixin), parent: parent}
+}
+
+func (s *scope) get(name string) (value, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return value{}, false
+}
+
+func (s *scope) getMixin(name string) (mixin, bool) {
+	for ; s != nil; s = s.parent {
+		if m, ok := s.mixins[name]; ok {
+			return m, true
+		}
+	}
+	return mixin{}, false
+}
+
+/*
+Assigns to an existing local variable, or declares one in s.
+Globals change only w/ bGlobal (or at the top level).
+*/
+func (s *scope) set(name string, v value, bGlobal bool) {
+	if bGlobal {
+		for s.parent != nil {
+			s = s.parent
+		}
+		s.vars[name] = v
+		return
+	}
+	for p := s; p.parent != nil; p = p.parent {
+		if _, ok := p.vars[name]; ok {
+			p.vars[name] = v
+			return
+		}
+	}
+	s.vars[name] = v
+}
+
+/*
+Output CSS: a rule (sels), an at-rule block (at), or a raw line.
+*/
+type node struct {
+	sels     []string
+	decls    []string
+	at       string
+	children []*node
+	raw      string
+}
+
+func (n *node) isEmpty() bool {
+	switch {
+	case len(n.raw) > 0:
+		return false
+	case len(n.sels) > 0:
+		return len(n.decls) == 0
+	}
+	for _, ch := range n.children {
+		if !ch.isEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+func writeNodes(sb *strings.Builder, sNodes []*node, indent string) {
+	bFirst := true
+	for _, n := range sNodes {
+		if n.isEmpty() {
+			continue
+		}
+		// blank line between top-level blocks
+		if !bFirst && (len(indent) == 0) && (len(n.raw) == 0) {
+			sb.WriteByte('\n')
+		}
+		bFirst = false
+
+		switch {
+		case len(n.raw) > 0:
+			sb.WriteString(indent + n.raw + "\n")
+		case len(n.sels) > 0:
+			sb.WriteString(indent + strings.Join(n.sels, ",\n"+indent) + " {\n")
+			for _, d := range n.decls {
+				sb.WriteString(indent + "  " + d + "\n")
+			}
+			sb.WriteString(indent + "}\n")
+		default:
+			sb.WriteString(indent + n.at + " {\n")
+			writeNodes(sb, n.children, indent+"  ")
+			sb.WriteString(indent + "}\n")
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package scss_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/BourgeoisBear/webjot/scss"
+)
+
+// partials, by `@import` name
+var testPartials = map[string]string{
+	"vars":      "$c: red !default;\n$w: 1px;\n",
+	"mixins":    "@mixin bad {\n  b: 1px + 2em;\n}\n",
+	"badunits":  "a {\n  b: 1px + 2em;\n}\n",
+	"badparse":  "a {\n  b: 1px;\n",
+	"nested":    "@import 'badunits';\n",
+	"overrides": "$c: blue;\n@import 'vars';\n",
+}
+
+func testImport(name, from string) (string, []byte, error) {
+	src, ok := testPartials[name]
+	if !ok {
+		return "", nil, fmt.Errorf("`%s` not found", name)
+	}
+	return "_" + name + ".scss", []byte(src), nil
+}
+
+func compile(src string) (string, error) {
+	var sb strings.Builder
+	err := scss.Compile(&sb, []byte(src), scss.Options{Filename: "main.scss", Import: testImport})
+	return sb.String(), err
+}
+
+func TestCompile(t *testing.T) {
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"nesting",
+			"a, b {\n  c: 1;\n  &:hover, &.x { d: 2; }\n  .e & { f: 3; }\n  g, h { i: 4; }\n}\n",
+			"a,\nb {\n  c: 1;\n}\n\n" +
+				"a:hover,\nb:hover,\na.x,\nb.x {\n  d: 2;\n}\n\n" +
+				".e a,\n.e b {\n  f: 3;\n}\n\n" +
+				"a g,\nb g,\na h,\nb h {\n  i: 4;\n}\n",
+		},
+		{
+			"default",
+			"$c: blue;\n$c: red !default;\n$d: green !default;\na { c: $c; d: $d; }\n",
+			"a {\n  c: blue;\n  d: green;\n}\n",
+		},
+		{
+			"default from partial",
+			"@import 'overrides';\na { c: $c; w: $w * 2; }\n",
+			"a {\n  c: blue;\n  w: 2px;\n}\n",
+		},
+		{
+			"global",
+			"$g: 1;\na {\n  $g: 2 !global;\n  b: $g;\n}\nc { g: $g; }\n",
+			"a {\n  b: 2;\n}\n\nc {\n  g: 2;\n}\n",
+		},
+		{
+			"local shadows global",
+			"$g: 1;\na {\n  $g: 2;\n  $l: 3;\n  b: $g $l;\n}\nc { g: $g; }\n",
+			"a {\n  b: 2 3;\n}\n\nc {\n  g: 1;\n}\n",
+		},
+		{
+			"mixin defaults & keywords",
+			"@mixin m($a, $b: 2px) { x: $a $b; }\na { @include m(1px); }\nb { @include m($b: 3px, $a: 4px); }\n",
+			"a {\n  x: 1px 2px;\n}\n\nb {\n  x: 4px 3px;\n}\n",
+		},
+		{
+			"mixin content",
+			"@mixin m { x: 1; @content; }\na { @include m { y: 2; &:hover { z: 3; } } }\n",
+			"a {\n  x: 1;\n  y: 2;\n}\n\na:hover {\n  z: 3;\n}\n",
+		},
+		{
+			"media bubbling",
+			".a {\n  c: 1;\n  @media (min-width: 10px) {\n    c: 2;\n    .b { d: 3; }\n  }\n}\n",
+			".a {\n  c: 1;\n}\n\n@media (min-width: 10px) {\n  .a {\n    c: 2;\n  }\n  .a .b {\n    d: 3;\n  }\n}\n",
+		},
+		{
+			"interpolation",
+			"$n: foo;\n$s: 3;\n.#{$n}-x { w: #{$s}px; content: \"a-#{$n}\"; }\n@media (min-width: #{$s * 10}px) { a { b: c; } }\n",
+			".foo-x {\n  w: 3px;\n  content: \"a-foo\";\n}\n\n@media (min-width: 30px) {\n  a {\n    b: c;\n  }\n}\n",
+		},
+		{
+			"content re-includes mixin",
+			"@mixin m { x: 1; @content; }\na { @include m { @include m; } }\n",
+			"a {\n  x: 1;\n  x: 1;\n}\n",
+		},
+		{
+			"concatenation",
+			"a { b: foo + bar; c: \"x\" + #fff; d: \"w\" + 2px; }\n",
+			"a {\n  b: foobar;\n  c: \"x#fff\";\n  d: \"w2px\";\n}\n",
+		},
+		{
+			"colons in values",
+			"a { b: url(http://x/y); c: \"a:b\"; --d: a:b; e: #{\"p:q\"}; }\n",
+			"a {\n  b: url(http://x/y);\n  c: \"a:b\";\n  --d: a:b;\n  e: p:q;\n}\n",
+		},
+		{
+			"arithmetic",
+			"a { b: 1px + 2px; c: 10px / 4; d: 2 * 3em; e: 50% + 10%; f: percentage(0.5); }\n",
+			"a {\n  b: 3px;\n  c: 10px / 4;\n  d: 6em;\n  e: 60%;\n  f: 50%;\n}\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := compile(tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		src  string
+		want scss.Error // Text ignored when empty
+	}{
+		{
+			"unit mismatch",
+			"a {\n  b: 1px + 2em;\n}\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 3, Msg: "incompatible units `px` and `em`", Text: "  b: 1px + 2em;"},
+		},
+		{
+			"unit product",
+			"a {\n  b: 1px * 2em;\n}\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 3, Msg: "`1px * 2em` isn't a valid CSS value"},
+		},
+		{
+			"undefined variable",
+			"a {\n  b: $nope;\n}\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 3, Msg: "undefined variable `$nope`"},
+		},
+		{
+			"unclosed block",
+			"x { y: z; }\na {\n  b: 1px;\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 1, Msg: "unclosed block (expected `}`)", Text: "a {"},
+		},
+		{
+			"undefined mixin",
+			"a {\n  @include nope;\n}\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 3, Msg: "undefined mixin `nope`"},
+		},
+		{
+			"too many arguments",
+			"@mixin m($a) { x: $a; }\na { @include m(1px, 2px); }\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 5, Msg: "mixin `m` takes 1 argument(s), got 2"},
+		},
+		{
+			"missing argument",
+			"@mixin m($a) { x: $a; }\na { @include m($b: 1px); }\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 5, Msg: "missing argument `$a` for mixin `m`"},
+		},
+		{
+			"recursive mixin",
+			"@mixin m {\n  x: 1;\n  @include m;\n}\na { @include m; }\n",
+			scss.Error{File: "main.scss", Line: 3, Col: 3, Msg: "mixin cycle: m -> m", Text: "  @include m;"},
+		},
+		{
+			"mutually recursive mixins",
+			"@mixin a { @include b; }\n@mixin b {\n  @include a;\n}\nx { @include a; }\n",
+			scss.Error{File: "main.scss", Line: 3, Col: 3, Msg: "mixin cycle: a -> b -> a"},
+		},
+		{
+			"recursion through content",
+			"@mixin m { @content; }\n@mixin n { @include m { @include n; } }\nx { @include n; }\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 25, Msg: "mixin cycle: n -> n"},
+		},
+		{
+			"duplicate parameter",
+			"a { b: c; }\n@mixin m($a, $b, $a: 1) { x: $a; }\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 1, Msg: "duplicate parameter `$a` for mixin `m`"},
+		},
+		{
+			"invalid parameter",
+			"@mixin m(a) { x: 1; }\n",
+			scss.Error{File: "main.scss", Line: 1, Col: 1, Msg: "invalid parameter `a` for mixin `m`"},
+		},
+		{
+			"empty variable name",
+			"$a: 1;\n$: 1;\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 1, Msg: "invalid variable name `$`", Text: "$: 1;"},
+		},
+		{
+			"color plus number",
+			"a {\n  b: rgba(0,0,0,0.5) + 1;\n}\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 3, Msg: "undefined operation `rgba(0, 0, 0, 0.5) + 1`"},
+		},
+		{
+			"hex color plus number",
+			"a { b: #fff + 1; }\n",
+			scss.Error{File: "main.scss", Line: 1, Col: 5, Msg: "undefined operation `#fff + 1`"},
+		},
+		{
+			"missing semicolon",
+			"a{ b: c\n d: e }\n",
+			scss.Error{File: "main.scss", Line: 1, Col: 4, Msg: "expected `;` after `b: c`", Text: "a{ b: c"},
+		},
+		{
+			"missing semicolon, one line",
+			"$x: 1\n$y: 2;\n",
+			scss.Error{File: "main.scss", Line: 1, Col: 1, Msg: "expected `;` after `$x: 1`"},
+		},
+		{
+			"import not found",
+			"a { b: c; }\n@import 'missing';\n",
+			scss.Error{File: "main.scss", Line: 2, Col: 1, Msg: "`missing` not found"},
+		},
+		{
+			"eval error in partial",
+			"a { b: c; }\n@import 'badunits';\n",
+			scss.Error{File: "_badunits.scss", Line: 2, Col: 3, Msg: "incompatible units `px` and `em`", Text: "  b: 1px + 2em;"},
+		},
+		{
+			"parse error in partial",
+			"@import 'badparse';\n",
+			scss.Error{File: "_badparse.scss", Line: 1, Col: 1, Msg: "unclosed block (expected `}`)", Text: "a {"},
+		},
+		{
+			"error in nested partial",
+			"@import 'nested';\n",
+			scss.Error{File: "_badunits.scss", Line: 2, Col: 3, Msg: "incompatible units `px` and `em`"},
+		},
+		{
+			"error in mixin from partial",
+			"@import 'mixins';\n\na { @include bad; }\n",
+			scss.Error{File: "_mixins.scss", Line: 2, Col: 3, Msg: "incompatible units `px` and `em`"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compile(tc.src)
+			var pErr *scss.Error
+			if !errors.As(err, &pErr) {
+				t.Fatalf("got %v, want *scss.Error", err)
+			}
+			got := *pErr
+			if len(tc.want.Text) == 0 {
+				got.Text = ""
+			}
+			if got != tc.want {
+				t.Errorf("got  %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
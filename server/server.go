@@ -0,0 +1,85 @@
+// Package server implements the HTTP server used in watch mode.
+package server
+
+import (
+	"net/http"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func OpenBrowser(url string) error {
+	var cmd string
+	var args []string
+	switch runtime.GOOS {
+	case "windows":
+		cmd = "cmd"
+		args = []string{"/c", "start", url}
+	case "darwin":
+		cmd = "open"
+		args = []string{url}
+	default: // "linux", "freebsd", "openbsd", "netbsd"
+		cmd = "xdg-open"
+		args = []string{url}
+	}
+	return exec.Command(cmd, args...).Start()
+}
+
+func HeadHandler(hDir http.FileSystem, oHandler http.Handler, rwm *sync.RWMutex) http.Handler {
+
+	return http.HandlerFunc(func(iWri http.ResponseWriter, pRq *http.Request) {
+
+		// mutexing between HTTP:HEAD and writes to /.pub/
+		// (for live.js issues w/ files in the process of being written)
+		rwm.RLock()
+		defer rwm.RUnlock()
+
+		if pRq.Method != "HEAD" {
+			oHandler.ServeHTTP(iWri, pRq)
+			return
+		}
+
+		var err error
+
+		defer func() {
+			if err == nil {
+				iWri.WriteHeader(http.StatusOK)
+				return
+			}
+			iWri.WriteHeader(http.StatusInternalServerError)
+			iWri.Write([]byte(err.Error()))
+		}()
+
+		// PROCESS URI
+		szPath := path.Clean(pRq.URL.Path)
+		if szPath == "/" {
+			szPath = "/index.html"
+		}
+
+		// OPEN FILE
+		oFile, err := hDir.Open(szPath)
+		if err != nil {
+			return
+		}
+		defer oFile.Close()
+
+		// GET THE CONTENT-TYPE OF THE FILE
+		FileHeader := make([]byte, 512)
+		oFile.Read(FileHeader)
+		FileContentType := http.DetectContentType(FileHeader)
+
+		// GET FILE SIZE
+		FileStat, err := oFile.Stat()
+		if err != nil {
+			return
+		}
+
+		// WRITE HEADER
+		iWri.Header().Set("Content-Type", FileContentType)
+		iWri.Header().Set("Content-Length", strconv.FormatInt(FileStat.Size(), 10))
+		iWri.Header().Set("Last-Modified", FileStat.ModTime().Format(time.RFC1123))
+	})
+}
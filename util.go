@@ -1,250 +1,112 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
-	"runtime"
-	"strconv"
+	"regexp"
 	"strings"
-	"sync"
-	"time"
-)
-
-type ErrMsg struct {
-	err error
-	msg string
-}
-
-func EWrap(err error, msg string) ErrMsg {
-	return ErrMsg{err: err, msg: msg}
-}
 
-func (e ErrMsg) Error() string {
-	return fmt.Sprintf("[%s] %v", e.msg, e.err)
-}
+	"github.com/BourgeoisBear/webjot/build"
+)
 
-func (e ErrMsg) Unwrap() error {
-	return e.err
-}
+var rxPprintExcl *regexp.Regexp
 
-func (e ErrMsg) Message() string {
-	return e.msg
-}
-
-func ErrRpt(err error, isTty bool) {
-	if err != nil {
-		if isTty {
-			fmt.Fprint(os.Stderr, "\x1b[91;1mERROR\x1b[0m: ")
-		} else {
-			fmt.Fprint(os.Stderr, "ERROR: ")
-		}
-
-		if ew, ok := err.(ErrMsg); ok {
-			msg := ew.Message()
-			hd, err := os.UserHomeDir()
-			if err == nil {
-				if strings.HasPrefix(msg, hd) {
-					msg = "~" + strings.TrimPrefix(msg, hd)
-				}
-			}
-			fmt.Fprintf(os.Stderr, "[%s] %v\n", msg, ew.Unwrap())
-		} else {
-			fmt.Fprintln(os.Stderr, err.Error())
-		}
-	}
+func init() {
+	rxPprintExcl = regexp.MustCompile(`DIR$|WATCHMODE`)
 }
 
 /*
-copies if:
-  - destination does not exist, OR
-  - destination has different size than source, OR
-  - destination has different mtime than source
+Returns a build.EventFunc that reports progress to STDOUT,
+and problems to STDERR.
 */
-func CopyOnDirty(dst, src string, fileMode os.FileMode) error {
-
-	fSrc, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer fSrc.Close()
-
-	iSrc, err := fSrc.Stat()
-	if err != nil {
-		return err
-	}
-
-	srcTime := iSrc.ModTime().Round(time.Second)
-	fnCopy := func() error {
-		err := func() error {
-			flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
-			fDst, err := os.OpenFile(dst, flags, fileMode)
-			if err != nil {
-				return err
+func cliEventHandler(bIsTty, bShowVars bool) build.EventFunc {
+
+	return func(ev build.Event) {
+		switch ev.Kind {
+		case build.EVT_BUILD:
+			if ev.DocType == build.DT_LAYOUT {
+				progressIndicator(ev.Src+" (LAYOUT)", bIsTty)
+			} else {
+				progressIndicator(ev.Src+" (DOCUMENT)", bIsTty)
 			}
-			defer fDst.Close()
-			_, err = io.Copy(fDst, fSrc)
-			return err
-		}()
-		if err != nil {
-			return err
+			if bShowVars && (ev.Doc != nil) {
+				ev.Doc.Vars.PrettyPrint(
+					os.Stdout, ev.Doc.NonConformingKeys, rxPprintExcl, bIsTty,
+				)
+			}
+		case build.EVT_CHANGE:
+			fmt.Printf("%-13s %q\n", ev.Msg, ev.Src)
+		case build.EVT_WARN:
+			WarnRpt(evtErr(ev), bIsTty)
+		case build.EVT_ERROR:
+			ErrRpt(evtErr(ev), bIsTty)
 		}
-		return os.Chtimes(dst, srcTime, srcTime)
 	}
+}
 
-	iDst, err := os.Stat(dst)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// fmt.Println("DOES NOT EXIST ", dst)
-			return fnCopy()
-		} else {
-			return err
+func evtErr(ev build.Event) error {
+	err := ev.Err
+	// already located
+	var te *build.TemplateError
+	if errors.As(err, &te) {
+		if te.File == ev.Src {
+			return te
 		}
+		err = te
+	}
+	if len(ev.Src) == 0 {
+		return err
 	}
-	dstTime := iDst.ModTime().Round(time.Second)
+	return build.EWrap(err, ev.Src)
+}
 
-	// copy if modified (fuzzy check)
-	if (iDst.Size() != iSrc.Size()) || (dstTime != srcTime) {
-		// fmt.Println("DIRTY ", dst, iDst.Size(), iSrc.Size(), dstTime, srcTime)
-		return fnCopy()
+func progressIndicator(msg string, bColor bool) {
+	if bColor {
+		fmt.Print("\x1b[96;1m>\x1b[0m ")
+	} else {
+		fmt.Print("> ")
 	}
+	fmt.Println(msg)
+}
 
-	return nil
+func ErrRpt(err error, isTty bool) {
+	rptMsg("ERROR", "\x1b[91;1m", err, isTty)
 }
 
-func searchDirAncestors(start, needle string) (found string, err error) {
+func WarnRpt(err error, isTty bool) {
+	rptMsg("WARNING", "\x1b[93;1m", err, isTty)
+}
 
-	start, err = filepath.Abs(start)
+func rptMsg(label, color string, err error, isTty bool) {
 	if err != nil {
-		return
-	}
-
-	defer func() {
-		if err != nil {
-			err = EWrap(err,
-				fmt.Sprintf("search for `%s` in `%s` ancestors", needle, start),
-			)
+		if isTty {
+			fmt.Fprint(os.Stderr, color+label+"\x1b[0m: ")
+		} else {
+			fmt.Fprint(os.Stderr, label+": ")
 		}
-	}()
-
-	cur := start
-	inf, err := os.Stat(cur)
-	if err != nil {
-		return
-	}
-
-	if !inf.IsDir() {
-		cur = filepath.Dir(cur)
-	}
-
-	if filepath.Base(cur) == needle {
-		found = cur
-		return
-	}
 
-	for {
-
-		// check siblings
-		var sD []os.DirEntry
-		if sD, err = os.ReadDir(cur); err != nil {
-			return
-		}
-		for ix := range sD {
-			if sD[ix].IsDir() {
-				sname := sD[ix].Name()
-				if sname == needle {
-					found = filepath.Join(cur, sname)
-					return
+		if ew, ok := err.(build.ErrMsg); ok {
+			msg := ew.Message()
+			hd, e2 := os.UserHomeDir()
+			if e2 == nil {
+				if strings.HasPrefix(msg, hd) {
+					msg = "~" + strings.TrimPrefix(msg, hd)
 				}
 			}
+			fmt.Fprintf(os.Stderr, "[%s] ", msg)
+			err = ew.Unwrap()
 		}
 
-		// up a dir
-		cur = filepath.Dir(cur)
+		fmt.Fprintln(os.Stderr, err.Error())
 
-		// exit at root
-		if strings.HasSuffix(cur, string(filepath.Separator)) {
-			break
+		// source excerpt for template errors
+		if te, ok := err.(*build.TemplateError); ok && (len(te.Excerpt) > 0) {
+			fmt.Fprintln(os.Stderr, indent(te.Excerpt, "    "))
 		}
 	}
-
-	err = os.ErrNotExist
-	return
 }
 
-func OpenBrowser(url string) error {
-	var cmd string
-	var args []string
-	switch runtime.GOOS {
-	case "windows":
-		cmd = "cmd"
-		args = []string{"/c", "start", url}
-	case "darwin":
-		cmd = "open"
-		args = []string{url}
-	default: // "linux", "freebsd", "openbsd", "netbsd"
-		cmd = "xdg-open"
-		args = []string{url}
-	}
-	return exec.Command(cmd, args...).Start()
-}
-
-func HeadHandler(hDir http.Dir, oHandler http.Handler, rwm *sync.RWMutex) http.Handler {
-
-	return http.HandlerFunc(func(iWri http.ResponseWriter, pRq *http.Request) {
-
-		// mutexing between HTTP:HEAD and writes to /.pub/
-		// (for live.js issues w/ files in the process of being written)
-		rwm.RLock()
-		defer rwm.RUnlock()
-
-		if pRq.Method != "HEAD" {
-			oHandler.ServeHTTP(iWri, pRq)
-			return
-		}
-
-		var err error
-
-		defer func() {
-			if err == nil {
-				iWri.WriteHeader(http.StatusOK)
-				return
-			}
-			iWri.WriteHeader(http.StatusInternalServerError)
-			iWri.Write([]byte(err.Error()))
-		}()
-
-		// PROCESS URI
-		szPath := path.Clean(pRq.URL.Path)
-		if szPath == "/" {
-			szPath = "/index.html"
-		}
-
-		// OPEN FILE
-		oFile, err := hDir.Open(szPath)
-		if err != nil {
-			return
-		}
-		defer oFile.Close()
-
-		// GET THE CONTENT-TYPE OF THE FILE
-		FileHeader := make([]byte, 512)
-		oFile.Read(FileHeader)
-		FileContentType := http.DetectContentType(FileHeader)
-
-		// GET FILE SIZE
-		FileStat, err := oFile.Stat()
-		if err != nil {
-			return
-		}
-
-		// WRITE HEADER
-		iWri.Header().Set("Content-Type", FileContentType)
-		iWri.Header().Set("Content-Length", strconv.FormatInt(FileStat.Size(), 10))
-		iWri.Header().Set("Last-Modified", FileStat.ModTime().Format(time.RFC1123))
-	})
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
 }
@@ -1,4 +1,5 @@
-package main
+// Package vars implements template variables: parsing from document headers & the environment, merging, and printing.
+package vars
 
 import (
 	"fmt"
@@ -12,6 +13,18 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+/*
+Prefix of OS environment variables that map to template variables,
+i.e. `$ZS_TITLE` <-> `.title`.
+*/
+const ENVVAR_PREFIX = "ZS_"
+
+/*
+Template include chain, passed to commands so that nested webjot runs can
+detect cycles.  Not imported by GetEnvGlobals().
+*/
+const ENVVAR_CHAIN = ENVVAR_PREFIX + "WEBJOT_CHAIN"
+
 type VarPair struct {
 	K string
 	V interface{}
@@ -50,6 +63,58 @@ func (mV Vars) GetStr(k string) string {
 	return ""
 }
 
+/*
+Returns a YAML list value as []string.
+A plain string value is split on commas.
+*/
+func (mV Vars) GetStrList(k string) []string {
+	switch tv := mV[k].(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		ret := make([]string, 0, len(tv))
+		for _, v := range tv {
+			ret = append(ret, fmt.Sprint(v))
+		}
+		return ret
+	case []string:
+		return tv
+	case string:
+		var ret []string
+		for _, s := range strings.Split(tv, ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return []string{mV.GetStr(k)}
+}
+
+/*
+Returns external filter commands from the `filters` key.
+A plain string value is a single command.
+*/
+func (mV Vars) GetFilters() ([]string, error) {
+	switch tv := mV["filters"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{tv}, nil
+	case []interface{}:
+		ret := make([]string, 0, len(tv))
+		for _, v := range tv {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("`filters`: expected command string, got %T", v)
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("`filters`: expected list of command strings, got %T", mV["filters"])
+}
+
 func (mV Vars) GetPairs(bSort bool) []VarPair {
 	ret := make([]VarPair, 0, len(mV))
 	for k, v := range mV {
@@ -168,7 +233,7 @@ func GetEnvGlobals() Vars {
 		if len(pair) < 2 {
 			continue
 		}
-		if !strings.HasPrefix(pair[0], ENVVAR_PREFIX) {
+		if !strings.HasPrefix(pair[0], ENVVAR_PREFIX) || (pair[0] == ENVVAR_CHAIN) {
 			continue
 		}
 		k := strings.TrimPrefix(pair[0], ENVVAR_PREFIX)
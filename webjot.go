@@ -1,7 +1,9 @@
 package main
 
 import (
+	"compress/gzip"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,172 +16,17 @@ import (
 	"sync"
 	"time"
 
-	"errors"
-
-	"github.com/fsnotify/fsnotify"
+	"github.com/BourgeoisBear/webjot/build"
+	"github.com/BourgeoisBear/webjot/server"
 	"github.com/mattn/go-isatty"
 )
 
-const (
-	CFGDIR        = ".webjot"
-	PUBDIR        = ".pub"
-	DEFAULT_DELIM = "@@@@@@@"
-	ENVVAR_PREFIX = "ZS_"
-)
-
 //go:embed all:default_conf
 var SiteCfgFS embed.FS
 
 const SiteCfgDirName = "default_conf"
 
-func buildAll(oB Builder, srcDir string) (Layout2Docs, Layouts, error) {
-
-	vinit := GetEnvGlobals()
-	mL2D := make(Layout2Docs)
-	mLayouts := make(Layouts)
-
-	// recurse through source dir
-	wdFunc := func(path string, info fs.DirEntry, eWalk error) error {
-
-		if eWalk != nil {
-			return EWrap(eWalk, path)
-		}
-
-		fname := info.Name()
-		bHidden := strings.HasPrefix(fname, ".")
-		if info.IsDir() {
-			// don't recurse hidden dirs except for ConfDir
-			if bHidden && (path != oB.ConfDir) {
-				return filepath.SkipDir
-			}
-			// recurse into ordinary dirs
-			return nil
-		}
-
-		// skip hidden files
-		if bHidden {
-			return nil
-		}
-
-		// build others
-		_, _, err := oB.buildFile(path, vinit, mL2D, mLayouts)
-		if err != nil {
-			ErrRpt(EWrap(err, path), oB.IsTty)
-		}
-		return nil
-	}
-	err := filepath.WalkDir(srcDir, wdFunc)
-	return mL2D, mLayouts, err
-}
-
-/*
-watches for changes to source and config files
-re-builds on change
-NOTE: blocking channel-select loop
-*/
-func watch(
-	oB Builder,
-	srcDir string,
-	mL2D Layout2Docs,
-	mLo Layouts,
-	rwm *sync.RWMutex,
-) error {
-
-	// create new pW
-	pW, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
-	defer pW.Close()
-
-	// add src dirs to watch
-	err = filepath.WalkDir(
-		srcDir,
-		func(src string, info fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			// only watch non-hidden dirs
-			if !info.IsDir() || strings.HasPrefix(info.Name(), ".") {
-				return nil
-			}
-			return pW.Add(src)
-		},
-	)
-	if err != nil {
-		return err
-	}
-
-	// add conf dir to watch
-	if err = pW.Add(oB.ConfDir); err != nil {
-		return err
-	}
-
-	vinit := GetEnvGlobals()
-
-	// listen for events
-	for {
-		select {
-		case evt, ok := <-pW.Events:
-			if !ok {
-				return nil
-			}
-
-			// rebuild file
-			if evt.Has(fsnotify.Write) || evt.Has(fsnotify.Create) {
-
-				// skip paths in PubDir
-				if strings.HasPrefix(evt.Name, oB.PubDir) {
-					continue
-				}
-				// skip hidden
-				if strings.HasPrefix(filepath.Base(evt.Name), ".") {
-					continue
-				}
-				// skip dirs
-				fi, err := os.Stat(evt.Name)
-				if err != nil {
-					ErrRpt(EWrap(err, evt.Name), oB.IsTty)
-					continue
-				}
-				if fi.IsDir() {
-					continue
-				}
-
-				fmt.Println(evt)
-
-				func() {
-					// mutexing between HTTP:HEAD and writes to /.pub/
-					// (for live.js issues w/ files in the process of being written)
-					rwm.Lock()
-					defer rwm.Unlock()
-
-					_, _, err := oB.buildFile(evt.Name, vinit, mL2D, mLo)
-					if err != nil {
-						ErrRpt(EWrap(err, evt.Name), oB.IsTty)
-						return
-					}
-
-					// TODO: track dependency graph, only re-build dirty
-					// parse layouts, render nested templates
-					oB.ApplyLayouts(mL2D, mLo, func(err error, msg string) {
-						ErrRpt(EWrap(err, msg), oB.IsTty)
-					})
-				}()
-			}
-
-		case err, ok := <-pW.Errors:
-			ErrRpt(err, oB.IsTty)
-			if !ok {
-				return nil
-			}
-		}
-	}
-
-	return nil
-}
-
-func initSite(oB Builder, tgtDir string) error {
+func initSite(tgtDir string, dirMode, fileMode os.FileMode, fnInfo func(string)) error {
 
 	tgtDir, err := filepath.Abs(tgtDir)
 	if err != nil {
@@ -194,7 +41,7 @@ func initSite(oB Builder, tgtDir string) error {
 
 		// make directory
 		if de.IsDir() {
-			err := os.Mkdir(dst, oB.DirMode)
+			err := os.Mkdir(dst, dirMode)
 			if os.IsExist(err) {
 				return nil
 			}
@@ -202,10 +49,10 @@ func initSite(oB Builder, tgtDir string) error {
 		}
 
 		// report progress
-		fmt.Println(dst)
+		fnInfo(dst)
 
 		// open dst (file must not exist)
-		fDst, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, oB.FileMode)
+		fDst, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, fileMode)
 		if err != nil {
 			if os.IsExist(err) {
 				err = errors.New("Init does not overwrite existing files.  Operation terminated.")
@@ -227,32 +74,165 @@ func initSite(oB Builder, tgtDir string) error {
 	})
 }
 
+/*
+Creates archive file `path`, and a Sink that writes into it.
+Archive type is chosen by file extension.
+fnClose finalizes the archive.
+*/
+func openArchiveSink(path string, fileMode os.FileMode) (
+	snk build.Sink, fnClose func() error, err error,
+) {
+	lpath := strings.ToLower(path)
+	bZip := strings.HasSuffix(lpath, ".zip")
+	bGzip := strings.HasSuffix(lpath, ".tar.gz") || strings.HasSuffix(lpath, ".tgz")
+	if !bZip && !bGzip && !strings.HasSuffix(lpath, ".tar") {
+		return nil, nil, fmt.Errorf("unsupported archive type `%s`", path)
+	}
+
+	pf, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileMode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bZip {
+		zs := build.NewZipSink(pf)
+		return zs, func() error {
+			err := zs.Close()
+			if e2 := pf.Close(); err == nil {
+				err = e2
+			}
+			return err
+		}, nil
+	}
+
+	var iWri io.Writer = pf
+	var gzw *gzip.Writer
+	if bGzip {
+		gzw = gzip.NewWriter(pf)
+		iWri = gzw
+	}
+	ts := build.NewTarSink(iWri, fileMode)
+	return ts, func() error {
+		err := ts.Close()
+		if gzw != nil {
+			if e2 := gzw.Close(); err == nil {
+				err = e2
+			}
+		}
+		if e2 := pf.Close(); err == nil {
+			err = e2
+		}
+		return err
+	}, nil
+}
+
+/*
+Reads sources for the site containing `tgt` as of git revision `rev`.
+Output defaults to the site's usual output dir.
+*/
+func gitSources(tgt, rev string) (fsys fs.FS, pubDir string, err error) {
+
+	if tgt, err = filepath.Abs(tgt); err != nil {
+		return
+	}
+	conf, err := build.SearchDirAncestors(tgt, build.CFGDIR)
+	if err != nil {
+		return
+	}
+	root := filepath.Dir(conf)
+	if fsys, err = build.GitFS(root, rev); err != nil {
+		return
+	}
+	return fsys, filepath.Join(root, build.PUBDIR), nil
+}
+
+/*
+Compares built output against golden dir `golden`, or re-writes `golden`
+when bUpdate is set.
+*/
+func checkGolden(
+	pub fs.FS, golden string, bUpdate bool, dirMode, fileMode os.FileMode,
+	pLog *cliLogger,
+) error {
+
+	if bUpdate {
+		if err := build.WriteGolden(pub, golden, dirMode, fileMode); err != nil {
+			return err
+		}
+		pLog.Info("updated " + golden)
+		return nil
+	}
+
+	diffs, err := build.DiffFS(pub, os.DirFS(golden))
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		pLog.Info("DIFF: " + d.String())
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d file(s) differ from %s", len(diffs), golden)
+	}
+	pLog.Info("output matches " + golden)
+	return nil
+}
+
 func main() {
 
 	bIsTty := isatty.IsTerminal(os.Stdout.Fd())
 
+	// replaced once -log-format is known
+	pLog := &cliLogger{bIsTty: bIsTty}
+
 	var err error
 	defer func() {
-		ErrRpt(err, bIsTty)
+		pLog.Fatal(err)
 		if err != nil {
 			os.Exit(1)
 		}
 	}()
 
-	oB := Builder{
+	opt := build.Options{
 		DirMode:  0755,
 		FileMode: 0644,
-		IsTty:    bIsTty,
 	}
 
-	var szDelim string
-	flag.StringVar(&szDelim, "vdelim", DEFAULT_DELIM, "vars/body delimiter")
-	flag.BoolVar(&oB.IsShowVars, "vshow", false, "show document vars for file(s) on build")
+	flag.StringVar(&opt.HdrDelim, "vdelim", build.DEFAULT_DELIM, "vars/body delimiter")
+	bShowVars := false
+	flag.BoolVar(&bShowVars, "vshow", false, "show document vars for file(s) on build")
+	szLogFmt := LOGFMT_TEXT
+	flag.StringVar(&szLogFmt, "log-format", LOGFMT_TEXT, "build log format: 'text' or 'json' (one JSON object per line)")
 
 	var httpPort int
-	flag.BoolVar(&oB.IsWatchMode, "watch", false, "rebuild on file change")
+	flag.BoolVar(&opt.IsStrict, "strict", false, "error on missing template vars & non-conforming header keys")
+	flag.BoolVar(&opt.IsKeepGoing, "keep-going", false, "continue building after file errors (default in watch mode)")
+	flag.BoolVar(&opt.IsWatchMode, "watch", false, "rebuild on file change")
 	flag.IntVar(&httpPort, "port", 8080, "HTTP port for watch-mode web server")
 
+	flag.DurationVar(&opt.Cmd.Timeout, "cmdtimeout", 0, "default timeout for doCmd commands (0 = none)")
+	flag.StringVar(&opt.Cmd.DirMode, "cmddir", build.CMDDIR_DOC, "doCmd working directory: 'doc' (document's dir) or 'root' (site root)")
+	flag.BoolVar(&opt.Cmd.IsStrict, "cmdstrict", false, "fail documents on doCmd errors & timeouts")
+	flag.BoolVar(&opt.Cmd.Cache.IsDisabled, "no-cache", false, "ignore & don't update cached doCmd output & image variants")
+
+	szArchive := ""
+	flag.StringVar(&szArchive, "archive", "", "build into a .zip, .tar, or .tar.gz archive instead of the output dir")
+	bInMem := false
+	flag.BoolVar(&bInMem, "inmem", false, "in watch mode, build into memory instead of the output dir")
+
+	szRev := ""
+	flag.StringVar(&szRev, "rev", "", "build sources as of this git revision (tag, branch, or commit)")
+
+	szGolden := ""
+	flag.StringVar(&szGolden, "check-golden", "", "build into memory, and compare output against this golden dir")
+	bUpdate := false
+	flag.BoolVar(&bUpdate, "update", false, "with -check-golden, re-write the golden dir from output")
+
+	bCheckLinks := false
+	flag.BoolVar(&bCheckLinks, "check-links", false, "after building, report broken internal links & #fragments in HTML output")
+
+	bValidate := false
+	flag.BoolVar(&bValidate, "validate", false, "after building, report structural problems in HTML output (errors w/ -strict)")
+
 	bInit := false
 	flag.BoolVar(&bInit, "init", false, "create a new site configuration inside the given directory")
 
@@ -273,7 +253,7 @@ The default delimiters '{{' and '}}' are escaped thus:
   {{ "}}" }}
 
 FLAG
-`, PUBDIR)
+`, build.PUBDIR)
 		flag.PrintDefaults()
 
 		fmt.Fprint(iWri, `
@@ -284,6 +264,18 @@ EXAMPLES
   re-build site:
     webjot <site_source_path>
 
+  compare site output against a golden copy (-update to re-write it):
+    webjot -check-golden <golden_dir> <site_source_path>
+
+  build site, then check links in its output:
+    webjot -check-links <site_source_path>
+
+  build site, then validate its HTML:
+    webjot -validate <site_source_path>
+
+  build site as of git tag v1.2:
+    webjot -rev v1.2 <site_source_path>
+
   update site contents w/ live refresh:
     webjot -watch <site_source_path>
 `)
@@ -293,13 +285,22 @@ EXAMPLES
 	flag.Parse()
 	args := flag.Args()
 
-	if len(szDelim) == 0 {
+	pl, err := newCliLogger(szLogFmt, os.Stdout, bIsTty, bShowVars)
+	if err != nil {
+		return
+	}
+	pLog = pl
+
+	if len(opt.HdrDelim) == 0 {
 		err = errors.New("empty vars/body delimiter")
 		return
-	} else {
-		if err = oB.SetHdrDelim(szDelim); err != nil {
-			return
-		}
+	}
+
+	switch opt.Cmd.DirMode {
+	case build.CMDDIR_DOC, build.CMDDIR_ROOT:
+	default:
+		err = fmt.Errorf("invalid -cmddir value `%s`", opt.Cmd.DirMode)
+		return
 	}
 
 	var tgt string
@@ -309,7 +310,7 @@ EXAMPLES
 
 	// create new site
 	if bInit {
-		err = initSite(oB, tgt)
+		err = initSite(tgt, opt.DirMode, opt.FileMode, pLog.Info)
 		return
 	}
 
@@ -319,35 +320,90 @@ EXAMPLES
 		}
 	}
 
-	// lookup conf dir parent
-	conf, err := searchDirAncestors(tgt, CFGDIR)
-	if err != nil {
+	// output destination
+	switch {
+	case (len(szArchive) > 0) && opt.IsWatchMode:
+		err = errors.New("-archive cannot be used with -watch")
+		return
+	case bInMem && !opt.IsWatchMode:
+		err = errors.New("-inmem requires -watch")
 		return
+	case (bCheckLinks || bValidate) && (opt.IsWatchMode || (len(szArchive) > 0)):
+		err = errors.New("-check-links & -validate cannot be used with -watch or -archive")
+		return
+	case bUpdate && (len(szGolden) == 0):
+		err = errors.New("-update requires -check-golden")
+		return
+	case len(szGolden) > 0:
+		if opt.IsWatchMode || (len(szArchive) > 0) {
+			err = errors.New("-check-golden cannot be used with -watch or -archive")
+			return
+		}
+		opt.Sink = build.NewMemSink()
+	case len(szArchive) > 0:
+		var fnClose func() error
+		if opt.Sink, fnClose, err = openArchiveSink(szArchive, opt.FileMode); err != nil {
+			return
+		}
+		defer func() {
+			if e2 := fnClose(); err == nil {
+				err = e2
+			}
+		}()
+	case bInMem:
+		opt.Sink = build.NewMemSink()
 	}
 
-	webRoot := filepath.Dir(conf)
-
-	// settings
-	oB.PubDir = filepath.Join(webRoot, PUBDIR)
-	oB.ConfDir = conf
-
-	// absolute paths
-	for _, ps := range []*string{&tgt, &oB.PubDir, &oB.ConfDir} {
-		if *ps, err = filepath.Abs(*ps); err != nil {
+	// historical sources from git
+	if len(szRev) > 0 {
+		if opt.IsWatchMode {
+			err = errors.New("-rev cannot be used with -watch")
+			return
+		}
+		if opt.SrcFS, opt.PubDir, err = gitSources(tgt, szRev); err != nil {
 			return
 		}
 	}
 
+	opt.SrcDir = tgt
+	opt.OnEvent = pLog.Event
+	pB, err := build.New(opt)
+	if err != nil {
+		return
+	}
+
 	// initial site build
-	mL2D, mLo, err := buildAll(oB, tgt)
+	err = pB.Build()
+	if errors.Is(err, build.ErrBuildFailed) && !pB.IsKeepGoing {
+		err = fmt.Errorf("%w (stopped at first error, see -keep-going)", err)
+	}
+	if err == nil {
+		var sErr []error
+		if bValidate {
+			sErr = append(sErr, pB.Validate())
+		}
+		if bCheckLinks {
+			sErr = append(sErr, pB.CheckLinks())
+		}
+		err = errors.Join(sErr...)
+	}
+	pLog.Summary(pB.Stats())
 	if err != nil {
+		// keep watching, so errors can be fixed
+		if !(pB.IsWatchMode && errors.Is(err, build.ErrBuildFailed)) {
+			return
+		}
+		pLog.Fatal(err)
+		err = nil
+	}
+
+	// golden output
+	if len(szGolden) > 0 {
+		err = checkGolden(pB.Output(), szGolden, bUpdate, opt.DirMode, opt.FileMode, pLog)
 		return
 	}
-	oB.ApplyLayouts(mL2D, mLo, func(err error, msg string) {
-		ErrRpt(EWrap(err, msg), oB.IsTty)
-	})
 
-	if oB.IsWatchMode {
+	if pB.IsWatchMode {
 
 		var rwm sync.RWMutex
 
@@ -355,28 +411,30 @@ EXAMPLES
 		go func() {
 
 			szPort := strconv.Itoa(httpPort)
-			fmt.Printf("serving %s on port %d\n", oB.PubDir, httpPort)
+			if bInMem {
+				pLog.Info(fmt.Sprintf("serving from memory on port %d", httpPort))
+			} else {
+				pLog.Info(fmt.Sprintf("serving %s on port %d", pB.PubDir, httpPort))
+			}
 
-			htdocs := http.Dir(oB.PubDir)
-			hdl := HeadHandler(htdocs, http.FileServer(htdocs), &rwm)
+			htdocs := http.FS(pB.Output())
+			hdl := server.HeadHandler(htdocs, http.FileServer(htdocs), &rwm)
 			http.Handle("/", hdl)
 
 			// open web browser
 			go func() {
 				time.Sleep(time.Second)
-				ErrRpt(OpenBrowser("http://localhost:"+szPort), bIsTty)
+				pLog.Error(server.OpenBrowser("http://localhost:" + szPort))
 			}()
 
 			// start http server
 			e2 := http.ListenAndServe(":"+szPort, nil)
-			if e2 != nil {
-				ErrRpt(e2, bIsTty)
-			}
+			pLog.Error(e2)
 
 		}()
 
 		// rebuild on change
-		err = watch(oB, webRoot, mL2D, mLo, &rwm)
+		err = pB.Watch(&rwm)
 
 	}
 }